@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"image"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/crimro-se/atlas-repacker/internal/atlas"
@@ -10,29 +14,251 @@ import (
 	mapset "github.com/deckarep/golang-set/v2"
 )
 
-func parseAtlasFile(filename string, imgRef int) ([]NamedBox, error) {
-	boxes := make([]NamedBox, 0)
+func parseAtlasFile(filename string) (*atlas.Atlas, error) {
 	fp, err := os.Open(filename)
 	if err != nil {
-		return boxes, fmt.Errorf("error whilst trying to open (%s): %w", filename, err)
+		return nil, fmt.Errorf("error whilst trying to open (%s): %w", filename, err)
 	}
 	defer fp.Close()
-	atlasRegions, err := atlas.ParseAtlasFile(fp)
+	a, err := atlas.Parse(fp)
 	if err != nil {
-		return boxes, fmt.Errorf("error whilst trying to parse (%s): %w", filename, err)
+		return nil, fmt.Errorf("error whilst trying to parse (%s): %w", filename, err)
 	}
-	return atlasToBoxes(imgRef, atlasRegions), nil
+	if len(a.Pages) == 0 {
+		return nil, fmt.Errorf("error whilst trying to parse (%s): no pages found", filename)
+	}
+	return a, nil
 }
 
-// converts atlasRegions type to []boxpack.BoxTranslation
-func atlasToBoxes(refImage int, ar atlas.AtlasRegions) []NamedBox {
-	boxes := make([]NamedBox, 0, len(ar))
-	for name, v := range ar {
-		boxes = append(boxes, NamedBoxFromBoxpack(boxpack.BoxFromRect(refImage, v.Rectangle, v.RotateRequired), name))
+// loads the page images of an atlas file, each paired with the page describing its regions.
+// page images are found relative to the atlas file. if input isn't nil, it's used as the page named
+// after inputSource's file, or the first page if none is, rather than loading that page.
+func loadAtlasPages(filename string, input image.Image, inputSource imageSource) ([]image.Image, []imageSource, error) {
+	a, err := parseAtlasFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	inputPage := -1
+	if input != nil {
+		inputPage = 0
+		for i, page := range a.Pages {
+			if filepath.Base(filepath.FromSlash(page.Name)) == filepath.Base(inputSource.filename) {
+				inputPage = i
+				break
+			}
+		}
+	}
+
+	images := make([]image.Image, 0, len(a.Pages))
+	sources := make([]imageSource, 0, len(a.Pages))
+	for i := range a.Pages {
+		page := &a.Pages[i]
+		if i == inputPage {
+			inputSource.page = page
+			images = append(images, input)
+			sources = append(sources, inputSource)
+			continue
+		}
+		path := filepath.Join(filepath.Dir(filename), filepath.FromSlash(page.Name))
+		imgs, srcs, err := loadImage(path, false)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error whilst loading page %s of (%s): %w", page.Name, filename, err)
+		}
+		srcs[0].page = page
+		images = append(images, imgs[0])
+		sources = append(sources, srcs[0])
+	}
+	return images, sources, nil
+}
+
+// converts atlas regions to boxes of image refImage.
+// boxes are sorted by name and index so packing results don't depend on the order regions were listed.
+func atlasToBoxes(refImage int, regions []atlas.Region) []NamedBox {
+	sorted := append([]atlas.Region(nil), regions...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].Index < sorted[j].Index
+	})
+	boxes := make([]NamedBox, 0, len(sorted))
+	for i := range sorted {
+		r := &sorted[i]
+		box := NamedBoxFromBoxpack(boxpack.BoxFromRect(refImage, r.Rectangle, r.Orientation), r.Name)
+		box.Region = r
+		boxes = append(boxes, box)
 	}
 	return boxes
 }
 
+// restores each trimmed atlas region to its original size, with its pixels where they were before trimming.
+// each restored region becomes an image of its own, described by a page holding only that region.
+func untrimRegions(images []image.Image, sources []imageSource) ([]image.Image, []imageSource) {
+	count := len(images)
+	for i := range count {
+		page := sources[i].page
+		if page == nil {
+			continue
+		}
+		kept := make([]atlas.Region, 0, len(page.Regions))
+		for _, r := range page.Regions {
+			if !r.Trimmed() {
+				kept = append(kept, r)
+				continue
+			}
+			canvas := image.NewNRGBA(image.Rectangle{Max: r.Orig})
+			box := boxpack.BoxFromRect(i, r.Rectangle, r.Orientation)
+			box.PlaceAt(r.TrimmedRect().Min)
+			boxpack.RenderAll(images, []boxpack.BoxTranslation{box}, canvas)
+
+			restored := r
+			restored.RotatableRect = atlas.RotatableRect{Rectangle: canvas.Rect}
+			restored.Offset = image.Point{}
+			single := *page
+			single.Regions = []atlas.Region{restored}
+			source := sources[i]
+			source.page = &single
+			images = append(images, canvas)
+			sources = append(sources, source)
+		}
+		remaining := *page
+		remaining.Regions = kept
+		sources[i].page = &remaining
+	}
+	return images, sources
+}
+
+// true if every image came from a premultiplied atlas page. their pixels are copied as they are,
+// so the output is premultiplied too. logs a warning if only some were, as the output then mixes both.
+func premultiplied(sources []imageSource) bool {
+	count := 0
+	for _, s := range sources {
+		if s.page != nil && s.page.PMA {
+			count++
+		}
+	}
+	if count > 0 && count < len(sources) {
+		msg("Warning: only some inputs have premultiplied alpha (pma), so the output mixes premultiplied and straight alpha")
+	}
+	return count == len(sources)
+}
+
+// writes a .atlas file describing all packed boxes as regions of their output pages
+func writeAtlasFile(filename string, pageFilenames []string, W, H int, pages [][]NamedBox, pma bool) error {
+	outPages := make([]atlas.OutputPage, 0, len(pages))
+	for i, boxes := range pages {
+		page := atlas.OutputPage{
+			Name: filepath.Base(pageFilenames[i]),
+			Size: image.Pt(W, H),
+			PMA:  pma,
+		}
+		for _, box := range boxes {
+			if !box.WasPacked() {
+				continue
+			}
+			// atlas bounds are in unrotated dimensions, even if the pixels are stored rotated
+			dest := box.DestRect()
+			bounds := image.Rectangle{Min: dest.Min, Max: dest.Min.Add(box.SourceRect().Size())}
+			region := atlas.OutputRegion{Name: box.Name, Index: -1, Bounds: bounds, Orientation: box.PackOrientation()}
+			if r := box.Region; r != nil {
+				// keep what the input atlas knew of the region
+				region.Index, region.Orig, region.Offset, region.Split, region.Pad = r.Index, r.Orig, r.Offset, r.Split, r.Pad
+			}
+			page.Regions = append(page.Regions, region)
+		}
+		outPages = append(outPages, page)
+	}
+
+	// buffered so an atlas that can't be written leaves any existing file alone
+	var buf bytes.Buffer
+	if err := atlas.WriteAtlasFile(&buf, outPages); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("error whilst trying to write (%s): %w", filename, err)
+	}
+	return nil
+}
+
+// generates stable names for detected islands from their source's prefix (see islandPrefixes) and index.
+// eg. walk.png -> walk_0, walk_1 ... or for frame 2 of walk.gif -> walk_f2_0, walk_f2_1 ...
+func islandNames(prefix string, count int) []string {
+	names := make([]string, count)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s_%d", prefix, i)
+	}
+	return names
+}
+
+// the prefix naming each source's detected islands: its filename without directory or extension,
+// then its frame if any. input files sharing a base name, eg. a/walk.png and b/walk.png, are numbered
+// in input order as walk_1 and walk_2 so their islands' names don't collide. a file is numbered likewise
+// when a prefix it would give names another input or is already given, eg. frame 2 of walk.gif and walk_f2.png.
+// sources with an atlas page are named by it, so get no prefix.
+func islandPrefixes(sources []imageSource) []string {
+	baseName := func(filename string) string {
+		base := filepath.Base(filename)
+		return base[:len(base)-len(filepath.Ext(base))]
+	}
+	withFrame := func(prefix string, frame int) string {
+		if frame < 0 {
+			return prefix
+		}
+		return fmt.Sprintf("%s_f%d", prefix, frame)
+	}
+	// the distinct files sharing each base name, and the frames loaded from each file, -1 for a whole file
+	files := make(map[string]mapset.Set[string])
+	frames := make(map[string][]int)
+	for _, s := range sources {
+		if s.page == nil {
+			filename := filepath.Clean(s.filename)
+			base := baseName(filename)
+			if files[base] == nil {
+				files[base] = mapset.NewThreadUnsafeSet[string]()
+			}
+			files[base].Add(filename)
+			frames[filename] = append(frames[filename], s.frame)
+		}
+	}
+
+	taken := mapset.NewThreadUnsafeSet[string]()
+	collides := func(prefix, base string, frames []int) bool {
+		for _, frame := range frames {
+			name := withFrame(prefix, frame)
+			if taken.Contains(name) || (name != base && files[name] != nil) {
+				return true
+			}
+		}
+		return false
+	}
+	fileprefixes := make(map[string]string)
+	numbered := make(map[string]int)
+	for _, s := range sources {
+		filename := filepath.Clean(s.filename)
+		if _, done := fileprefixes[filename]; done || s.page != nil {
+			continue
+		}
+		base := baseName(filename)
+		prefix := base
+		for (files[base].Cardinality() > 1 && prefix == base) || collides(prefix, base, frames[filename]) {
+			numbered[base]++
+			prefix = fmt.Sprintf("%s_%d", base, numbered[base])
+		}
+		fileprefixes[filename] = prefix
+		for _, frame := range frames[filename] {
+			taken.Add(withFrame(prefix, frame))
+		}
+	}
+
+	prefixes := make([]string, len(sources))
+	for i, s := range sources {
+		if s.page == nil {
+			prefixes[i] = withFrame(fileprefixes[filepath.Clean(s.filename)], s.frame)
+		}
+	}
+	return prefixes
+}
+
 // filters a slice of NamedBox to only contain the named members specified
 // case insensitive
 func namedBoxFilter(boxes []NamedBox, csv string) []NamedBox {
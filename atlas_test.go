@@ -0,0 +1,111 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/crimro-se/atlas-repacker/internal/atlas"
+	"github.com/crimro-se/atlas-repacker/internal/orientation"
+)
+
+func TestUntrimRegions(t *testing.T) {
+	page := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	// a 3x2 region, trimmed from 6x5 with 1 pixel to its left and 2 beneath it
+	trimmed := atlas.Region{Name: "a", Index: -1, Orig: image.Pt(6, 5), Offset: image.Pt(1, 2),
+		RotatableRect: atlas.RotatableRect{Rectangle: image.Rect(0, 0, 3, 2)}}
+	// the same, but stored rotated: physically 2x3 at 8,0
+	rotated := trimmed
+	rotated.Name, rotated.RotatableRect = "b", atlas.RotatableRect{Rectangle: image.Rect(8, 0, 11, 2), Orientation: orientation.Orientation{Rotate: 90}}
+	untouched := atlas.Region{Name: "c", Index: -1, Orig: image.Pt(2, 2),
+		RotatableRect: atlas.RotatableRect{Rectangle: image.Rect(0, 8, 2, 10)}}
+
+	logical := func(x, y int) color.NRGBA { return color.NRGBA{uint8(x), uint8(y), 0, 255} }
+	for y := range 2 {
+		for x := range 3 {
+			page.SetNRGBA(x, y, logical(x, y))
+			// stored rotated 90 degrees counter-clockwise
+			page.SetNRGBA(8+y, 2-x, logical(x, y))
+		}
+	}
+
+	sources := []imageSource{{filename: "sheet.png", frame: -1, page: &atlas.Page{Name: "sheet.png", Regions: []atlas.Region{trimmed, rotated, untouched}}}}
+	images, sources := untrimRegions([]image.Image{page}, sources)
+	if len(images) != 3 {
+		t.Fatalf("expected the page and 2 restored regions, got %d images", len(images))
+	}
+	if regions := sources[0].page.Regions; len(regions) != 1 || regions[0].Name != "c" {
+		t.Errorf("the page should keep only its untrimmed region, has %v", regions)
+	}
+
+	for i, name := range []string{"a", "b"} {
+		img, region := images[1+i], sources[1+i].page.Regions[0]
+		if region.Name != name || region.Trimmed() || region.Orientation != orientation.Upright || region.Rectangle != image.Rect(0, 0, 6, 5) {
+			t.Errorf("%s: unexpected restored region %+v", name, region)
+		}
+		for y := range 5 {
+			for x := range 6 {
+				var want color.NRGBA
+				// the region's pixels belong at 1,1: 2 rows from the bottom
+				if x >= 1 && x < 4 && y >= 1 && y < 3 {
+					want = logical(x-1, y-1)
+				}
+				if got := color.NRGBAModel.Convert(img.At(x, y)); got != want {
+					t.Errorf("%s (%d,%d): got %v, want %v", name, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestIslandPrefixes(t *testing.T) {
+	sources := []imageSource{
+		{filename: "a/walk.png", frame: -1},
+		{filename: "run.gif", frame: 0},
+		{filename: "run.gif", frame: 1},
+		{filename: "b/walk.png", frame: -1},
+		{filename: "walk_1.png", frame: -1},
+		{filename: "sheet.png", frame: -1, page: &atlas.Page{Name: "sheet.png"}},
+		{filename: "c/walk.gif", frame: 2},
+		{filename: "a/../a/walk.png", frame: -1},
+		{filename: "jump.gif", frame: 2},
+		{filename: "jump_f2.png", frame: -1},
+		{filename: "walk_4_f2.png", frame: -1},
+	}
+	// walk_1 names an input of its own, so the walks skip it. walk.gif skips walk_4 as its frame 2
+	// would be named like walk_4_f2.png, and jump.gif is numbered so as not to be named like jump_f2.png
+	want := []string{"walk_2", "run_f0", "run_f1", "walk_3", "walk_1", "", "walk_5_f2", "walk_2", "jump_1_f2", "jump_f2", "walk_4_f2"}
+	if got := islandPrefixes(sources); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLoadMalformedSiblingAtlas(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "walk.png")
+	fp, err := os.Create(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(fp, image.NewNRGBA(image.Rect(0, 0, 8, 8)))
+	fp.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed := "walk.png\nsize: 8, 8\nidle\n  bounds: 0, 0, x, 4\n"
+	if err := os.WriteFile(filepath.Join(dir, "walk.atlas"), []byte(malformed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	images, sources, err := loadAllImages([]string{input}, false, true)
+	if err != nil {
+		t.Fatalf("a malformed sibling atlas should fall back to detection, got %v", err)
+	}
+	if len(images) != 1 || len(sources) != 1 || sources[0].page != nil || sources[0].filename != input {
+		t.Errorf("got %d images, sources %v, want %s with islands to detect", len(images), sources, input)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// file extensions searched for when an input is a directory
+var imageExtensions = map[string]bool{".png": true, ".gif": true, ".jpg": true, ".jpeg": true, ".webp": true}
+
+// an image found by batch mode, along with its path relative to the directory or glob which found it
+type batchInput struct {
+	path string
+	rel  string
+}
+
+// the outcome of repacking a single batch input, as written to the joblog
+type batchResult struct {
+	input, output string
+	start         time.Time
+	runtime       time.Duration
+	unpacked      int
+	err           error
+}
+
+// 0 if everything was packed, otherwise 1
+func (r batchResult) exitval() int {
+	if r.err != nil || r.unpacked > 0 {
+		return 1
+	}
+	return 0
+}
+
+// repacks each image found within args separately, mirroring them into flags.batchDir,
+// then writes the joblog. returns the exit status.
+func runBatch(flags myFlags, args []string) int {
+	inputs, err := expandInputs(args, flags.recursive, flags.batchDir)
+	if err == nil && len(inputs) == 0 {
+		err = errors.New("no input images found")
+	}
+	if err != nil {
+		logErrors([]error{err})
+		return 1
+	}
+
+	// each job gets an equal share of the detection threads
+	jobFlags := flags
+	jobFlags.threads = max(1, flags.threads/flags.jobs)
+
+	results := make([]batchResult, len(inputs))
+	// what each output path would overwrite, inputs included
+	outputs := make(map[string]string, 2*len(inputs))
+	for _, in := range inputs {
+		outputs[absPath(in.path)] = "input " + in.path
+	}
+	// with -pages, a job writes numbered pages named after its output instead. outputs differing only
+	// by page number can't share a page, so only inputs need checking against them.
+	overwrites := func(output string) (string, bool) {
+		if other, taken := outputs[absPath(output)]; taken {
+			return other, true
+		}
+		for _, in := range inputs {
+			if flags.pages && isPageFilename(absPath(in.path), absPath(output)) {
+				return "input " + in.path, true
+			}
+		}
+		return "", false
+	}
+	todo := make(chan int)
+	var wg sync.WaitGroup
+	for range min(flags.jobs, len(inputs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range todo {
+				results[i] = runBatchJob(jobFlags, inputs[i].path, results[i].output)
+			}
+		}()
+	}
+	for i, in := range inputs {
+		output := batchOutputPath(flags.batchDir, flags.template, in)
+		results[i] = batchResult{input: in.path, output: output}
+		if other, taken := overwrites(output); taken {
+			results[i].err = fmt.Errorf("output (%s) would overwrite %s", output, other)
+			logErrors([]error{results[i].err})
+			continue
+		}
+		outputs[absPath(output)] = "the output of " + in.path
+		todo <- i
+	}
+	close(todo)
+	wg.Wait()
+
+	joblog := flags.joblog
+	if joblog == "" {
+		joblog = filepath.Join(flags.batchDir, "joblog.txt")
+	}
+	if err := writeJoblog(joblog, results); err != nil {
+		logErrors([]error{err})
+		return 1
+	}
+
+	var failed, incomplete int
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+		} else if r.unpacked > 0 {
+			incomplete++
+		}
+	}
+	msg(fmt.Sprintf("Batch complete: %d succeeded, %d failed, %d with unpacked boxes. %s has been written",
+		len(results)-failed-incomplete, failed, incomplete, joblog))
+	if failed+incomplete > 0 {
+		return 1
+	}
+	return 0
+}
+
+// repacks a single input to output, with -debug and -rejected images named after output.
+func runBatchJob(flags myFlags, input, output string) batchResult {
+	result := batchResult{input: input, output: output, start: time.Now()}
+	base := strings.TrimSuffix(output, filepath.Ext(output))
+	flags.outputFileName = output
+	flags.debugFileName = base + "_debug.png"
+	if flags.rejectedOut != "" {
+		flags.rejectedOut = base + "_rejected.png"
+	}
+
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		result.err = fmt.Errorf("error whilst trying to create directory (%s): %w", filepath.Dir(output), err)
+	} else {
+		result.unpacked, result.err = repack(flags, []string{input})
+	}
+	result.runtime = time.Since(result.start)
+
+	if result.err != nil {
+		logErrors([]error{fmt.Errorf("error whilst repacking (%s): %w", input, result.err)})
+	} else {
+		msg(input + " has been repacked to " + output)
+	}
+	return result
+}
+
+// expands directories and globs in args into the image files they contain.
+// directories are only searched recursively if recursive is set, and skip (eg. the output directory) never is,
+// even when given or matched itself.
+// other files are used as given, whatever their extension.
+func expandInputs(args []string, recursive bool, skip string) ([]batchInput, error) {
+	inputs := make([]batchInput, 0, len(args))
+	seen := make(map[string]bool)
+	add := func(path, rel string) {
+		path = filepath.Clean(path)
+		if !seen[path] {
+			seen[path] = true
+			inputs = append(inputs, batchInput{path: path, rel: rel})
+		}
+	}
+
+	for _, arg := range args {
+		root := arg
+		matches := []string{arg}
+		if hasGlobMeta(arg) {
+			var err error
+			if matches, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("error whilst expanding glob (%s): %w", arg, err)
+			}
+			for hasGlobMeta(root) {
+				root = filepath.Dir(root)
+			}
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if info.IsDir() && sameFile(match, skip) {
+				continue
+			}
+			if !info.IsDir() {
+				rel := filepath.Base(match)
+				if root != match {
+					rel, _ = filepath.Rel(root, match)
+				}
+				add(match, rel)
+				continue
+			}
+			err = filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() {
+					if path != match && (!recursive || sameFile(path, skip)) {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if imageExtensions[strings.ToLower(filepath.Ext(path))] {
+					rel, _ := filepath.Rel(root, path)
+					add(path, rel)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error whilst searching directory (%s): %w", match, err)
+			}
+		}
+	}
+	return inputs, nil
+}
+
+// true if path contains any of the wildcards of filepath.Match
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// path made absolute, for comparing paths given in different forms
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// true if both paths exist and are the same file or directory
+func sameFile(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// expands template for input, within dir.
+func batchOutputPath(dir, template string, input batchInput) string {
+	relDir := filepath.Dir(input.rel)
+	if relDir == "." {
+		relDir = ""
+	}
+	ext := filepath.Ext(input.rel)
+	name := filepath.Base(input.rel)
+	name = name[:len(name)-len(ext)]
+	r := strings.NewReplacer("{dir}", relDir, "{name}", name, "{ext}", strings.TrimPrefix(ext, "."))
+	return filepath.Join(dir, filepath.FromSlash(r.Replace(template)))
+}
+
+// writes a tab separated summary of each job, in the spirit of GNU parallel's --joblog.
+func writeJoblog(filename string, results []batchResult) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return fmt.Errorf("error whilst trying to create directory (%s): %w", filepath.Dir(filename), err)
+	}
+	fp, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("error whilst trying to create (%s): %w", filename, err)
+	}
+	defer fp.Close()
+
+	bw := bufio.NewWriter(fp)
+	fmt.Fprintln(bw, "Seq\tStarttime\tJobRuntime\tExitval\tUnpacked\tInput\tOutput\tError")
+	for i, r := range results {
+		var start float64
+		if !r.start.IsZero() {
+			start = float64(r.start.UnixMilli()) / 1000
+		}
+		var errText string
+		if r.err != nil {
+			errText = strings.ReplaceAll(r.err.Error(), "\n", " ")
+		}
+		fmt.Fprintf(bw, "%d\t%.3f\t%.3f\t%d\t%d\t%s\t%s\t%s\n",
+			i+1, start, r.runtime.Seconds(), r.exitval(), r.unpacked, r.input, r.output, errText)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("error whilst writing (%s): %w", filename, err)
+	}
+	return nil
+}
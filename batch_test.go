@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// creates each of files (slash separated, relative to dir) as an empty file
+func makeTree(t *testing.T, dir string, files ...string) {
+	t.Helper()
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+	makeTree(t, dir, "a.png", "b.JPG", "notes.txt", "sub/c.gif", "sub/deeper/d.webp", "out/old.png")
+	in := func(path, rel string) batchInput {
+		return batchInput{path: filepath.Join(dir, filepath.FromSlash(path)), rel: filepath.FromSlash(rel)}
+	}
+	out := filepath.Join(dir, "out")
+
+	tests := []struct {
+		name      string
+		args      []string
+		recursive bool
+		want      []batchInput
+	}{
+		{"directory", []string{dir}, false, []batchInput{in("a.png", "a.png"), in("b.JPG", "b.JPG")}},
+		{"recursive", []string{dir}, true, []batchInput{
+			in("a.png", "a.png"), in("b.JPG", "b.JPG"), in("sub/c.gif", "sub/c.gif"), in("sub/deeper/d.webp", "sub/deeper/d.webp"),
+		}},
+		{"file", []string{filepath.Join(dir, "sub", "c.gif")}, false, []batchInput{in("sub/c.gif", "c.gif")}},
+		{"glob", []string{filepath.Join(dir, "*.png")}, false, []batchInput{in("a.png", "a.png")}},
+		{"glob matching directories", []string{filepath.Join(dir, "s*")}, true, []batchInput{
+			in("sub/c.gif", "sub/c.gif"), in("sub/deeper/d.webp", "sub/deeper/d.webp"),
+		}},
+		{"duplicates", []string{filepath.Join(dir, "a.png"), dir}, false, []batchInput{in("a.png", "a.png"), in("b.JPG", "b.JPG")}},
+		{"output directory matched", []string{filepath.Join(dir, "[os]*")}, true, []batchInput{
+			in("sub/c.gif", "sub/c.gif"), in("sub/deeper/d.webp", "sub/deeper/d.webp"),
+		}},
+		{"output directory given", []string{out}, false, []batchInput{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandInputs(tt.args, tt.recursive, out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := expandInputs([]string{filepath.Join(dir, "missing.png")}, false, out); err == nil {
+		t.Error("expected an error for a missing input")
+	}
+}
+
+func TestBatchOutputPath(t *testing.T) {
+	tests := []struct {
+		template, rel, want string
+	}{
+		{"{dir}/{name}.png", "walk.png", "out/walk.png"},
+		{"{dir}/{name}.png", "hero/walk.gif", "out/hero/walk.png"},
+		{"{name}_{ext}_repacked.png", "hero/walk.gif", "out/walk_gif_repacked.png"},
+		{"{dir}/packed/{name}.png", "a/b/walk.png", "out/a/b/packed/walk.png"},
+	}
+	for _, tt := range tests {
+		got := batchOutputPath("out", tt.template, batchInput{rel: filepath.FromSlash(tt.rel)})
+		if got != filepath.FromSlash(tt.want) {
+			t.Errorf("%s with %s: got %s, want %s", tt.template, tt.rel, got, tt.want)
+		}
+	}
+}
+
+// repacking in place, so that outputs would overwrite their inputs, is refused
+func TestBatchInPlace(t *testing.T) {
+	dir := t.TempDir()
+	makeTree(t, dir, "walk.png", "run.png")
+	flags := myFlags{batchDir: dir, template: "{dir}/{name}.png", jobs: 1, threads: 1}
+	inputs := []string{filepath.Join(dir, "walk.png"), filepath.Join(dir, "r*.png")}
+	if status := runBatch(flags, inputs); status != 1 {
+		t.Errorf("exit status %d, want 1", status)
+	}
+
+	joblog, err := os.ReadFile(filepath.Join(dir, "joblog.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(joblog), "would overwrite input"); n != 2 {
+		t.Errorf("%d inputs reported as overwritten, want 2:\n%s", n, joblog)
+	}
+	for _, f := range []string{"walk.png", "run.png"} {
+		if info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f))); err != nil || info.Size() != 0 {
+			t.Errorf("input %s was modified", f)
+		}
+	}
+}
+
+func TestBatchPagesOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	makeTree(t, dir, "walk.png", "walk_sheet_1.png")
+	flags := myFlags{batchDir: dir, template: "{dir}/{name}_sheet.png", jobs: 1, threads: 1, pages: true}
+	if status := runBatch(flags, []string{filepath.Join(dir, "walk.png"), filepath.Join(dir, "walk_sheet_1.png")}); status != 1 {
+		t.Errorf("exit status %d, want 1", status)
+	}
+
+	joblog, err := os.ReadFile(filepath.Join(dir, "joblog.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(joblog), "walk_sheet.png) would overwrite input "+filepath.Join(dir, "walk_sheet_1.png")) {
+		t.Errorf("walk.png's pages weren't reported as overwriting walk_sheet_1.png:\n%s", joblog)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "walk_sheet_1.png")); err != nil || info.Size() != 0 {
+		t.Error("input walk_sheet_1.png was modified")
+	}
+}
+
+func TestIsPageFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"out/walk_0.png", true},
+		{"out/walk_12.png", true},
+		{"out/walk.png", false},
+		{"out/walk_.png", false},
+		{"out/walk_01.png", false},
+		{"out/walk_-1.png", false},
+		{"out/walk_1.gif", false},
+		{"out/walk_1_0.png", false},
+		{"walk_0.png", false},
+	}
+	for _, tt := range tests {
+		if got := isPageFilename(tt.name, "out/walk.png"); got != tt.want {
+			t.Errorf("isPageFilename(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -38,15 +38,44 @@ func BoxpackSliceFromNamedBoxes(boxes []NamedBox) []boxpack.BoxTranslation {
 	return boxTR
 }
 
-// invoke boxpack.PackBoxes whilst adapting []NamedBox to []boxpack.BoxTranslation
-func PackNamedBoxes(boxes []NamedBox, W, H, boxMargin, offset int) int {
+// invoke boxpack.PackBoxesWith whilst adapting []NamedBox to []boxpack.BoxTranslation
+// when cfg has several strategies, the best result is kept and its strategy returned.
+func PackNamedBoxes(boxes []NamedBox, cfg boxpack.PackConfig, W, H, boxMargin, offset int) (int, boxpack.Strategy) {
 	boxTR := BoxpackSliceFromNamedBoxes(boxes)
-	unpacked := boxpack.PackBoxes(boxTR, W, H, boxMargin, offset)
+	unpacked, strategy := boxpack.PackBoxesWith(cfg, boxTR, W, H, boxMargin, offset)
 	// apply results
 	for i, _ := range boxes {
 		boxes[i].BoxTranslation = boxTR[i]
 	}
-	return unpacked
+	return unpacked, strategy
+}
+
+// invoke boxpack.PackPages whilst adapting []NamedBox to []boxpack.BoxTranslation
+// returns the boxes placed on each page and the count of any remaining unpacked.
+func PackAllNamedBoxes(boxes []NamedBox, cfg boxpack.PackConfig, W, H, boxMargin, offset, maxPages int) ([][]NamedBox, int) {
+	boxTR := BoxpackSliceFromNamedBoxes(boxes)
+	pageIDs, unpacked := boxpack.PackPages(cfg, boxTR, W, H, boxMargin, offset, maxPages)
+	pages := make([][]NamedBox, len(pageIDs))
+	for i, ids := range pageIDs {
+		pages[i] = make([]NamedBox, 0, len(ids))
+		for _, id := range ids {
+			boxes[id].BoxTranslation = boxTR[id]
+			pages[i] = append(pages[i], boxes[id])
+		}
+	}
+	return pages, unpacked
+}
+
+// adaptor for boxpack.FindMaxMargin
+func FindMaxMargin(boxes []NamedBox, cfg boxpack.PackConfig, W, H, start int) (int, int) {
+	boxTR := BoxpackSliceFromNamedBoxes(boxes)
+	return boxpack.FindMaxMargin(cfg, boxTR, W, H, start)
+}
+
+// adaptor for boxpack.FindMinSize
+func FindMinSize(boxes []NamedBox, cfg boxpack.PackConfig, margin int, constraints boxpack.SizeConstraints) (int, int, int) {
+	boxTR := BoxpackSliceFromNamedBoxes(boxes)
+	return boxpack.FindMinSize(cfg, boxTR, margin, constraints)
 }
 
 // adaptor for boxpack.EstimateOutputWH
@@ -5,14 +5,28 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"runtime"
+	"strings"
+
+	"github.com/crimro-se/atlas-repacker/internal/boxpack"
+	"github.com/crimro-se/atlas-repacker/internal/chroma"
+	"github.com/crimro-se/atlas-repacker/internal/findislands"
 )
 
 type myFlags struct {
-	outputFileName                                      string
-	checkDiagonals, maximumMarginMode, loadAtlas, debug bool
-	width, height, margin, align, minimumSquareMode     int
+	outputFileName                                                                    string
+	checkDiagonals, maximumMarginMode, loadAtlas, atlasOut, debug, pages, allowRotate bool
+	minimumSizeMode, powerOfTwo, fixedWidth, masks, gifFrames, recursive, untrim      bool
+	width, height, margin, align, minimumSquareMode, maxPages, sizeMultiple           int
+	alphaThreshold, lumaThreshold, minPixels, minWidth, minHeight, mergeDistance      int
+	threads, jobs                                                                     int
+	maxAspect, maxArea                                                                float64
 
-	atlasFilter string
+	atlasFilter, chromaKey, rejectedOut, containment string
+	packerNames, heuristicNames, sortNames           string
+	batchDir, template, joblog                       string
+	packing                                          boxpack.PackConfig // built by buildPackConfig after validation
+	debugFileName                                    string             // where -debug writes, named after the output in batch mode
 }
 
 func initFlags() {
@@ -26,15 +40,86 @@ func getFlags() (myFlags, []string) {
 	flag.StringVar(&flags.atlasFilter, "filter", "",
 		"Comma separated string of attachment names in the atlas file to allow. Case insensitive.")
 	flag.BoolVar(&flags.loadAtlas, "atlas", false,
-		"When set, loads pixel region information from .atlas files with same name.")
+		"When set, loads pixel region information from .atlas files with same name.\n"+
+			"Regions on other pages of the atlas are loaded from those page images. .atlas files can also be given as inputs directly.")
+	flag.BoolVar(&flags.untrim, "untrim", false,
+		"When set, atlas regions whose transparent edges were trimmed are restored to their original size before packing.\n"+
+			"Otherwise their trim is kept, and written by -atlasout.")
+	flag.BoolVar(&flags.atlasOut, "atlasout", false,
+		"When set, writes a Spine/libGDX .atlas file describing the output, named after the output file.")
 	flag.BoolVar(&flags.debug, "debug", false,
 		"When set, writes a debug.png image demonstrating all detected/loaded islands.")
 	flag.BoolVar(&flags.checkDiagonals, "diagonal", false,
 		"When set, diagonally adjacent pixels are considered connected during island detection.")
+	flag.StringVar(&flags.chromaKey, "chroma", "",
+		"Colour to treat as transparent, for inputs without alpha such as JPEGs. #RRGGBB[,tolerance] or auto[,tolerance].\nauto detects the colour from each image's corners.")
+	flag.BoolVar(&flags.gifFrames, "gifframes", false,
+		"When set, every frame of an animated GIF is a separate input, as it would be displayed. Otherwise only the first frame is used.\n"+
+			"Region names include the frame, eg. walk_f2_0 is island 0 of frame 2. Frames are always detected, never loaded via -atlas.")
+	flag.BoolVar(&flags.masks, "mask", false,
+		"When set, only the pixels of each detected island are copied, excluding other islands intruding into its bounding box.")
+	flag.IntVar(&flags.alphaThreshold, "alpha", 0,
+		"During island detection, pixels with alpha at or below this (0-254) are treated as transparent.\nUseful for faint anti-aliased halos and alpha noise.")
+	flag.IntVar(&flags.lumaThreshold, "luma", 0,
+		"During island detection, pixels with luminance below this (0-255) are treated as transparent.\nUseful for sprites on a black background.")
+	flag.IntVar(&flags.minPixels, "minpixels", 0,
+		"Islands with fewer pixels than this are rejected, eg. compression specks.")
+	flag.IntVar(&flags.minWidth, "minwidth", 0,
+		"Islands narrower than this are rejected.")
+	flag.IntVar(&flags.minHeight, "minheight", 0,
+		"Islands shorter than this are rejected.")
+	flag.Float64Var(&flags.maxArea, "maxarea", 0,
+		"Islands whose bounding box covers more than this fraction (0-1) of their image are rejected. 0 = no limit.")
+	flag.StringVar(&flags.rejectedOut, "rejected", "",
+		"If set, writes the pixels of rejected islands to this image file for review, numbered as -pages does when there are several inputs.\nRejected islands are always logged.")
+	flag.IntVar(&flags.mergeDistance, "merge", 0,
+		"Islands whose bounding boxes come within this many pixels of each other are merged into one, eg. a character and their detached weapon.\n1 joins touching boxes, including diagonally. An alternative to -diagonal. 0 = no merging.")
+	flag.StringVar(&flags.containment, "contained", "warn",
+		"What to do with an island lying within another's bounding box, whose box would copy its pixels again.\n"+
+			"absorb = merge it into the larger island, keep = keep both (requires -mask), warn = keep both and log how many there are.\n"+
+			"With -debug, such islands are each logged and drawn red.")
+	flag.IntVar(&flags.threads, "threads", runtime.NumCPU(),
+		"Number of threads detecting islands. Several images are detected at once, and large images are split into bands.\nResults don't depend on this.")
+	flag.StringVar(&flags.batchDir, "batch", "",
+		"If set, each input image is repacked separately into this directory, and inputs may be directories or globs.\n"+
+			"The input tree is mirrored, named by -template. -debug and -rejected images are written beside each output, eg. walk_debug.png.")
+	flag.BoolVar(&flags.recursive, "recursive", false,
+		"With -batch, directories are searched recursively for images.")
+	flag.StringVar(&flags.template, "template", "{dir}/{name}.png",
+		"With -batch, the output filename within the -batch directory.\n"+
+			"{dir} = the input's directory relative to the directory or glob given, {name} = its filename without extension, {ext} = its extension.")
+	flag.IntVar(&flags.jobs, "jobs", runtime.NumCPU(),
+		"With -batch, number of inputs repacked at once. -threads are shared between them.")
+	flag.StringVar(&flags.joblog, "joblog", "",
+		"With -batch, where to write a tab separated summary of each input's result. Defaults to joblog.txt in the -batch directory.")
 	flag.BoolVar(&flags.maximumMarginMode, "findmaxmargin", false,
 		"When set, will find the largest margin value for which all islands still fit in the output.")
 	flag.IntVar(&flags.minimumSquareMode, "findminsquare", 0,
 		"If set > 0, finds the smallest output image size for which w and h is a multiple of this value.")
+	flag.BoolVar(&flags.pages, "pages", false,
+		"When set, boxes that don't fit are placed on additional output pages, named output_0.png, output_1.png etc.")
+	flag.IntVar(&flags.maxPages, "maxpages", 0,
+		"Maximum number of output pages to use in -pages mode. 0 = no limit.")
+	flag.StringVar(&flags.packerNames, "packer", "skyline",
+		"Comma separated list of packing algorithms to try, the best result is kept.\nOptions: "+
+			strings.Join(boxpack.PackerNames(), ", ")+", or all.")
+	flag.StringVar(&flags.heuristicNames, "heuristic", "bl",
+		"Comma separated list of skyline packer heuristics to try, bl (bottom left), bf (best fit) or all.")
+	flag.StringVar(&flags.sortNames, "sort", "height",
+		"Comma separated list of orders in which to pack boxes, largest first. The best result is kept.\nOptions: height, width, area, perimeter, maxside, none (input order), or all.")
+	flag.BoolVar(&flags.allowRotate, "allowrotate", false,
+		"When set, the packer may rotate boxes 90 degrees to improve fit. Rotated boxes are written with rotate: 90 by -atlasout.")
+	flag.BoolVar(&flags.minimumSizeMode, "findminsize", false,
+		"When set, finds the output size of least area for which all islands fit.\nConstrained by -pot, -multiple, -maxaspect and -fixedwidth.\n"+
+			"Only certain to be the least with the default skyline packer, other packers may find a slightly larger size.")
+	flag.BoolVar(&flags.powerOfTwo, "pot", false,
+		"With -findminsize, output width and height must be powers of two.")
+	flag.IntVar(&flags.sizeMultiple, "multiple", 0,
+		"With -findminsize, output width and height must be multiples of this value.")
+	flag.Float64Var(&flags.maxAspect, "maxaspect", 0,
+		"With -findminsize, the longer output side may be at most this many times the shorter. 0 = no limit.")
+	flag.BoolVar(&flags.fixedWidth, "fixedwidth", false,
+		"With -findminsize, keeps the width given by -w and only finds the minimal height.")
 	flag.IntVar(&flags.width, "w", 512,
 		"Width of output image.")
 	flag.IntVar(&flags.height, "h", 512,
@@ -45,13 +130,16 @@ func getFlags() (myFlags, []string) {
 		"How to align a box within its margin?\n0 = top left, 1 = center, 2 = bottom right.")
 
 	flag.Parse()
+	flags.debugFileName = "debug.png"
 	inputFiles := flag.Args()
 	return flags, inputFiles
 }
 
 func usage() {
 	fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n\n", os.Args[0])
-	fmt.Fprintln(flag.CommandLine.Output(), os.Args[0], "[flags]", "[input.png] [input2.png ...]")
+	fmt.Fprintln(flag.CommandLine.Output(), os.Args[0], "[flags]", "[input.png | input.atlas] [input2.png ...]")
+	fmt.Fprintln(flag.CommandLine.Output(), os.Args[0], "-batch outdir", "[flags]", "[dir | glob | input.png ...]")
+	fmt.Fprintln(flag.CommandLine.Output(), os.Args[0], "validate", "[flags]", "input.atlas [input2.atlas ...]")
 	fmt.Fprintln(flag.CommandLine.Output(), "Flags:")
 	flag.PrintDefaults()
 }
@@ -68,8 +156,113 @@ func validateFlags(flags myFlags, inputs []string) []error {
 		errs = append(errs, errors.New("invalid alignment. Should be 0, 1 or 2"))
 	}
 
-	if flags.margin < 0 || flags.width < 1 || flags.height < 1 {
+	if flags.margin < 0 || flags.maxPages < 0 || flags.width < 1 || flags.height < 1 || flags.sizeMultiple < 0 {
 		errs = append(errs, errors.New("an input parameter specified is too small or negative"))
 	}
+
+	if flags.alphaThreshold < 0 || flags.alphaThreshold > 254 {
+		errs = append(errs, errors.New("-alpha should be 0 to 254"))
+	}
+
+	if flags.lumaThreshold < 0 || flags.lumaThreshold > 255 {
+		errs = append(errs, errors.New("-luma should be 0 to 255"))
+	}
+
+	if flags.threads < 1 {
+		errs = append(errs, errors.New("-threads should be at least 1"))
+	}
+
+	if flags.batchDir != "" {
+		if flags.jobs < 1 {
+			errs = append(errs, errors.New("-jobs should be at least 1"))
+		}
+		if !strings.Contains(flags.template, "{name}") {
+			errs = append(errs, errors.New("-template must contain {name}, otherwise outputs overwrite each other"))
+		}
+	}
+
+	if flags.mergeDistance < 0 {
+		errs = append(errs, errors.New("-merge can't be negative"))
+	}
+
+	if c, err := findislands.ContainmentByName(flags.containment); err != nil {
+		errs = append(errs, err)
+	} else if c == findislands.ContainKeep && !flags.masks {
+		errs = append(errs, errors.New("-contained keep requires -mask, otherwise nested islands are copied twice"))
+	}
+
+	if flags.minPixels < 0 || flags.minWidth < 0 || flags.minHeight < 0 || flags.maxArea < 0 || flags.maxArea > 1 {
+		errs = append(errs, errors.New("island size filters can't be negative, and -maxarea is at most 1"))
+	}
+
+	if flags.maxAspect != 0 && flags.maxAspect < 1 {
+		errs = append(errs, errors.New("-maxaspect should be at least 1, or 0 for no limit"))
+	}
+
+	if flags.minimumSizeMode && flags.minimumSquareMode > 0 {
+		errs = append(errs, errors.New("-findminsize and -findminsquare can't be used together"))
+	}
+
+	if flags.minimumSizeMode && flags.fixedWidth {
+		if flags.powerOfTwo && flags.width&(flags.width-1) != 0 {
+			errs = append(errs, fmt.Errorf("-fixedwidth keeps -w %d, which isn't a power of two as -pot requires", flags.width))
+		}
+		if flags.sizeMultiple > 0 && flags.width%flags.sizeMultiple != 0 {
+			errs = append(errs, fmt.Errorf("-fixedwidth keeps -w %d, which isn't a multiple of -multiple %d", flags.width, flags.sizeMultiple))
+		}
+	}
+
+	if flags.chromaKey != "" {
+		if _, err := chroma.ParseKey(flags.chromaKey); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if _, err := buildPackConfig(flags); err != nil {
+		errs = append(errs, err)
+	}
 	return errs
 }
+
+// builds the packing config from the -packer, -heuristic, -sort and -allowrotate flags
+func buildPackConfig(flags myFlags) (boxpack.PackConfig, error) {
+	var cfg boxpack.PackConfig
+	packers, err := parseList(flags.packerNames, boxpack.PackersByName)
+	if err != nil {
+		return cfg, err
+	}
+	heuristics, err := parseList(flags.heuristicNames, boxpack.SkylineHeuristicsByName)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Sorts, err = parseList(flags.sortNames, boxpack.SortOrdersByName)
+	if err != nil {
+		return cfg, err
+	}
+
+	// each skyline packer is tried with every requested heuristic
+	for _, p := range packers {
+		if _, ok := p.(boxpack.SkylinePacker); ok {
+			for _, h := range heuristics {
+				cfg.Packers = append(cfg.Packers, boxpack.SkylinePacker{Heuristic: h})
+			}
+		} else {
+			cfg.Packers = append(cfg.Packers, p)
+		}
+	}
+	cfg.AllowRotate = flags.allowRotate
+	return cfg, nil
+}
+
+// parses a comma separated list of names via byName. Case insensitive.
+func parseList[T any](csv string, byName func(string) ([]T, error)) ([]T, error) {
+	items := make([]T, 0)
+	for _, name := range strings.Split(csv, ",") {
+		item, err := byName(strings.ToLower(strings.TrimSpace(name)))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item...)
+	}
+	return items, nil
+}
@@ -3,14 +3,14 @@ module github.com/crimro-se/atlas-repacker
 go 1.23.2
 
 require (
+	github.com/deckarep/golang-set/v2 v2.7.0
+	github.com/disintegration/imaging v1.6.2
 	github.com/rs/zerolog v1.33.0
 	golang.org/x/exp v0.0.0-20241009180824-f66d83c29e7c
 	golang.org/x/image v0.22.0
 )
 
 require (
-	github.com/deckarep/golang-set/v2 v2.7.0 // indirect
-	github.com/disintegration/imaging v1.6.2 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	golang.org/x/sys v0.26.0 // indirect
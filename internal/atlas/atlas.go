@@ -1,22 +1,84 @@
 package atlas
 
 import (
-	"bufio"
-	"errors"
 	"fmt"
 	"image"
 	"io"
 	"path/filepath"
-	"strconv"
-	"strings"
+
+	"github.com/crimro-se/atlas-repacker/internal/orientation"
 )
 
-type AtlasRegions map[string]RotatableRect
+type AtlasRegions map[RegionKey]Region
+
+// identifies a region. frames of an animation share a name, differing by index.
+type RegionKey struct {
+	Name  string
+	Index int
+}
+
+// a parsed atlas file: one or more page images, each holding regions
+type Atlas struct {
+	Pages []Page
+}
+
+// a page image and the regions upon it
+type Page struct {
+	Name    string      // filename of the page image, relative to the atlas file
+	Size    image.Point // pixel dimensions of the page image, zero if not given
+	Format  string      // pixel format, eg. RGBA8888
+	Filter  string      // minification and magnification filters, eg. Linear,Linear
+	Repeat  string      // texture wrapping: none, x, y or xy
+	PMA     bool        // true if the page image's colours are premultiplied by alpha
+	Scale   float64     // scale the page was packed at, 1 if not given
+	Regions []Region
+	Line    int // line of the atlas file naming the page, for diagnostics
+}
+
+// a named region of a page
+type Region struct {
+	Name  string
+	Index int // frame number within an animation whose frames share Name, -1 if it isn't one
+	RotatableRect
+	Orig   image.Point // size of the original image, before its transparent edges were trimmed
+	Offset image.Point // position of the trimmed rect within the original image, from its bottom left as libGDX measures it
+	Split  []int       // nine-patch split lines: left, right, top, bottom. nil if not a nine-patch
+	Pad    []int       // nine-patch content padding: left, right, top, bottom. nil if not given
+	Line   int         // line of the atlas file naming the region, for diagnostics
+}
 
-// a rect that may require rotation
+func (r Region) Key() RegionKey {
+	return RegionKey{Name: r.Name, Index: r.Index}
+}
+
+func (k RegionKey) String() string {
+	if k.Index >= 0 {
+		return fmt.Sprintf("'%s' index %d", k.Name, k.Index)
+	}
+	return "'" + k.Name + "'"
+}
+
+// true if transparent edges were trimmed from the original image
+func (r Region) Trimmed() bool {
+	return r.Orig != r.Size() || r.Offset != image.Point{}
+}
+
+// the rect the region's pixels occupy within its original image, relative to the original's top left
+func (r Region) TrimmedRect() image.Rectangle {
+	topLeft := image.Pt(r.Offset.X, r.Orig.Y-r.Offset.Y-r.Dy())
+	return image.Rectangle{Min: topLeft, Max: topLeft.Add(r.Size())}
+}
+
+// the pixels the rect occupies on its page, whose dimensions are swapped if it's stored rotated by 90 or 270 degrees
+func (r RotatableRect) StoredRect() image.Rectangle {
+	return image.Rectangle{Min: r.Min, Max: r.Min.Add(r.Orientation.StoredSize(r.Size()))}
+}
+
+// a rect whose pixels may be stored rotated. The rect's size is upright, as displayed,
+// so if the orientation swaps axes the stored pixels are Dy() wide and Dx() tall.
 type RotatableRect struct {
 	image.Rectangle
-	RotateRequired bool // True if this region should be rotated 90 degrees clockwise
+	Orientation orientation.Orientation // how the region's pixels are stored on the page
 }
 
 // Edits filenames, replacing extensions with .atlas
@@ -31,116 +93,19 @@ func FilepathsToDotAtlas(filenames []string) []string {
 	return modifiedFilenames
 }
 
-// parse atlas file data
+// parse atlas file data into a flat map of regions, regardless of page.
+// where a name and index repeat, the last region wins.
 func ParseAtlasFile(data io.Reader) (AtlasRegions, error) {
-	filedata, err := parseAtlasFileToMap(data)
+	a, err := Parse(data)
 	if err != nil {
 		return nil, err
 	}
 
 	regions := make(AtlasRegions)
-	for currentRegion, attrs := range filedata {
-		rotate := attrs["rotate"] == "true" || attrs["rotate"] == "90"
-		if bounds, ok := attrs["bounds"]; ok {
-			x, y, w, h, err := parse4Ints(bounds)
-			if err != nil {
-				return nil, err
-			}
-			regions[currentRegion] = buildRect(x, y, w, h, rotate)
-		} else if xy, ok := attrs["xy"]; ok {
-			size, ok := attrs["size"]
-			if !ok {
-				return nil, fmt.Errorf("error in atlas file, xy attribute presented but size is missing")
-			}
-			x, y, err := parse2Ints(xy)
-			if err != nil {
-				return nil, err
-			}
-			w, h, err := parse2Ints(size)
-			if err != nil {
-				return nil, err
-			}
-			regions[currentRegion] = buildRect(x, y, w, h, rotate)
-		} else {
-			return nil, fmt.Errorf("error in atlas file, boundary completely unknown for '%s'", currentRegion)
+	for _, page := range a.Pages {
+		for _, r := range page.Regions {
+			regions[r.Key()] = r
 		}
 	}
 	return regions, nil
 }
-
-// digests the file into a map of string (region name) to (map of (parameter name) to parameter value.)
-func parseAtlasFileToMap(data io.Reader) (map[string]map[string]string, error) {
-	mappedData := make(map[string]map[string]string, 0)
-	scanner := bufio.NewScanner(data)
-	currentRegion := ""
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		if len(line) == 0 || strings.HasSuffix(line, ".png") || strings.HasSuffix(line, ".webp") || strings.HasSuffix(line, ".gif") {
-			continue
-		}
-		if !strings.Contains(line, ":") {
-			currentRegion = line
-			if _, exists := mappedData[currentRegion]; !exists {
-				mappedData[currentRegion] = make(map[string]string)
-			}
-			continue
-		}
-
-		// If we haven't encountered a region name yet, skip this line
-		if currentRegion == "" {
-			continue
-		}
-
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) < 2 {
-			return nil, errors.New("problem parsing atlas file")
-		}
-		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
-		mappedData[currentRegion][key] = value
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-	return mappedData, nil
-}
-
-// builds a rect that might require a deferred rotation
-func buildRect(x, y, w, h int, rotate bool) RotatableRect {
-	var r RotatableRect
-	r.RotateRequired = rotate
-	r.Rectangle = image.Rect(x, y, x+w, y+h)
-	return r
-}
-
-func parse2Ints(str string) (int, int, error) {
-	coords := strings.Split(str, ",")
-	if len(coords) != 2 {
-		return 0, 0, errors.New("expected 2 ints whilst parsing atlas file")
-	}
-	x, err := strconv.Atoi(strings.TrimSpace(coords[0]))
-	if err != nil {
-		return 0, 0, err
-	}
-	y, err := strconv.Atoi(strings.TrimSpace(coords[1]))
-	if err != nil {
-		return 0, 0, err
-	}
-	return x, y, nil
-}
-
-func parse4Ints(str string) (int, int, int, int, error) {
-	coords := strings.Split(str, ",")
-	if len(coords) != 4 {
-		return 0, 0, 0, 0, errors.New("expected 4 ints whilst parsing atlas file")
-	}
-	var fourInts [4]int
-	var err error
-	for i, v := range coords {
-		fourInts[i], err = strconv.Atoi(strings.TrimSpace(v))
-		if err != nil {
-			return 0, 0, 0, 0, err
-		}
-	}
-	return fourInts[0], fourInts[1], fourInts[2], fourInts[3], nil
-}
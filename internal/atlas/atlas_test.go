@@ -0,0 +1,258 @@
+package atlas
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/crimro-se/atlas-repacker/internal/orientation"
+)
+
+func TestWriteAtlasRoundTrip(t *testing.T) {
+	page := OutputPage{Name: "out.png", Size: image.Pt(64, 64)}
+	page.Regions = append(page.Regions, OutputRegion{Name: "a", Index: -1, Bounds: image.Rect(0, 0, 10, 20)})
+	page.Regions = append(page.Regions, OutputRegion{Name: "b", Index: -1, Bounds: image.Rect(11, 0, 41, 5)})
+
+	var buf bytes.Buffer
+	if err := WriteAtlasFile(&buf, []OutputPage{page}); err != nil {
+		t.Fatal(err)
+	}
+	regions, err := ParseAtlasFile(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regions) != len(page.Regions) {
+		t.Fatalf("expected %d regions, got %d", len(page.Regions), len(regions))
+	}
+	for _, r := range page.Regions {
+		got := regions[RegionKey{r.Name, -1}]
+		if got.Rectangle != r.Bounds || got.Trimmed() {
+			t.Errorf("region %s: expected %v untrimmed, got %v offset %v orig %v", r.Name, r.Bounds, got.Rectangle, got.Offset, got.Orig)
+		}
+	}
+}
+
+var rotated90 = orientation.Orientation{Rotate: 90}
+
+const multiPageAtlas = `
+hero.png
+size: 128,64
+format: RGBA8888
+filter: Linear,MipMapLinearLinear
+repeat: none
+pma: true
+scale: 0.5
+head
+  bounds: 0,0,10,20
+body
+  bounds: 10,0,30,40
+  rotate: 90
+
+hero_2.png
+size: 32,32
+format: RGBA4444
+filter: Nearest,Nearest
+repeat: xy
+legs
+  xy: 2, 4
+  size: 8, 16
+  rotate: true
+`
+
+func TestParsePages(t *testing.T) {
+	a, err := Parse(strings.NewReader(multiPageAtlas))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Page{
+		{Name: "hero.png", Size: image.Pt(128, 64), Format: "RGBA8888", Filter: "Linear,MipMapLinearLinear", Repeat: "none", PMA: true, Scale: 0.5, Line: 2,
+			Regions: []Region{
+				{Name: "head", Index: -1, RotatableRect: RotatableRect{Rectangle: image.Rect(0, 0, 10, 20)}, Orig: image.Pt(10, 20), Line: 9},
+				{Name: "body", Index: -1, RotatableRect: RotatableRect{Rectangle: image.Rect(10, 0, 40, 40), Orientation: rotated90}, Orig: image.Pt(30, 40), Line: 11},
+			}},
+		{Name: "hero_2.png", Size: image.Pt(32, 32), Format: "RGBA4444", Filter: "Nearest,Nearest", Repeat: "xy", Scale: 1, Line: 15,
+			Regions: []Region{
+				{Name: "legs", Index: -1, RotatableRect: RotatableRect{Rectangle: image.Rect(2, 4, 10, 20), Orientation: rotated90}, Orig: image.Pt(8, 16), Line: 20},
+			}},
+	}
+	if !reflect.DeepEqual(a.Pages, want) {
+		t.Errorf("got %+v\nwant %+v", a.Pages, want)
+	}
+
+	// the flat map still sees every region
+	regions, err := ParseAtlasFile(strings.NewReader(multiPageAtlas))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regions) != 3 || regions[RegionKey{"legs", -1}].Rectangle != image.Rect(2, 4, 10, 20) {
+		t.Errorf("unexpected regions %v", regions)
+	}
+}
+
+func TestParsePageErrors(t *testing.T) {
+	for _, data := range []string{
+		"a.png\nsize: 1\n",
+		"a.png\npma: maybe\n",
+		"a.png\nscale: big\n",
+		"a.png\nr\n  bounds: 1,2,3\n",
+		"a.png\nr\n  rotate: true\n",
+	} {
+		if _, err := Parse(strings.NewReader(data)); err == nil {
+			t.Errorf("expected an error parsing %q", data)
+		}
+	}
+}
+
+func TestWritePMA(t *testing.T) {
+	var buf bytes.Buffer
+	pages := []OutputPage{{Name: "a.png", Size: image.Pt(8, 8), PMA: true}, {Name: "b.png", Size: image.Pt(8, 8)}}
+	if err := WriteAtlasFile(&buf, pages); err != nil {
+		t.Fatal(err)
+	}
+	a, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Pages) != 2 || !a.Pages[0].PMA || a.Pages[1].PMA {
+		t.Errorf("pma not round tripped: %+v", a.Pages)
+	}
+}
+
+func TestWriteFlipped(t *testing.T) {
+	var buf bytes.Buffer
+	pages := []OutputPage{
+		{Name: "a.png", Size: image.Pt(8, 8), Regions: []OutputRegion{{Name: "ok", Index: -1, Bounds: image.Rect(0, 0, 4, 4)}}},
+		{Name: "b.png", Size: image.Pt(8, 8), Regions: []OutputRegion{
+			{Name: "flipped", Index: -1, Bounds: image.Rect(0, 0, 4, 4), Orientation: orientation.Orientation{Flip: true}},
+		}},
+	}
+	if err := WriteAtlasFile(&buf, pages); err == nil {
+		t.Fatal("a flipped region was written")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("%d bytes written before the flipped region was found", buf.Len())
+	}
+}
+
+// a legacy libGDX atlas, with trimmed, indexed and nine-patch regions
+const legacyAtlas = `
+sheet.png
+format: RGBA8888
+filter: Nearest,Nearest
+repeat: none
+walk
+  rotate: false
+  xy: 0, 0
+  size: 10, 12
+  orig: 16, 16
+  offset: 2, 1
+  index: 0
+walk
+  rotate: true
+  xy: 10, 0
+  size: 8, 14
+  orig: 16, 16
+  offset: 4, 0
+  index: 1
+button
+  rotate: false
+  xy: 0, 20
+  size: 24, 24
+  split: 4, 5, 6, 7
+  pad: 1, 2, 3, 4
+  orig: 24, 24
+  offset: 0, 0
+  index: -1
+`
+
+func TestParseLegacy(t *testing.T) {
+	regions, err := ParseAtlasFile(strings.NewReader(legacyAtlas))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regions) != 3 {
+		t.Fatalf("expected 3 regions, animation frames kept apart, got %d", len(regions))
+	}
+
+	walk0 := regions[RegionKey{"walk", 0}]
+	if !walk0.Trimmed() || walk0.Orig != image.Pt(16, 16) || walk0.Offset != image.Pt(2, 1) {
+		t.Errorf("walk 0: unexpected trim, orig %v offset %v", walk0.Orig, walk0.Offset)
+	}
+	// 1 pixel was trimmed from the bottom, so 3 from the top
+	if got := walk0.TrimmedRect(); got != image.Rect(2, 3, 12, 15) {
+		t.Errorf("walk 0: trimmed rect %v, want %v", got, image.Rect(2, 3, 12, 15))
+	}
+	walk1 := regions[RegionKey{"walk", 1}]
+	if walk1.Rectangle != image.Rect(10, 0, 18, 14) || walk1.Orientation != rotated90 || walk1.TrimmedRect() != image.Rect(4, 2, 12, 16) {
+		t.Errorf("walk 1: unexpected %v %v, trimmed rect %v", walk1.Rectangle, walk1.Orientation, walk1.TrimmedRect())
+	}
+
+	button := regions[RegionKey{"button", -1}]
+	if button.Trimmed() || !reflect.DeepEqual(button.Split, []int{4, 5, 6, 7}) || !reflect.DeepEqual(button.Pad, []int{1, 2, 3, 4}) {
+		t.Errorf("button: unexpected nine-patch %+v", button)
+	}
+}
+
+// everything a legacy region holds survives being written in the Spine 4 format and parsed again
+func TestWriteLegacyRoundTrip(t *testing.T) {
+	original, err := Parse(strings.NewReader(legacyAtlas))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := OutputPage{Name: "out.png", Size: image.Pt(64, 64)}
+	for _, r := range original.Pages[0].Regions {
+		page.Regions = append(page.Regions, OutputRegion{
+			Name: r.Name, Index: r.Index, Bounds: r.Rectangle, Orientation: r.Orientation,
+			Orig: r.Orig, Offset: r.Offset, Split: r.Split, Pad: r.Pad,
+		})
+	}
+	var buf bytes.Buffer
+	if err := WriteAtlasFile(&buf, []OutputPage{page}); err != nil {
+		t.Fatal(err)
+	}
+	written, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the lines naming each region differ
+	for i := range written.Pages[0].Regions {
+		written.Pages[0].Regions[i].Line = original.Pages[0].Regions[i].Line
+	}
+	if !reflect.DeepEqual(written.Pages[0].Regions, original.Pages[0].Regions) {
+		t.Errorf("got %+v\nwant %+v", written.Pages[0].Regions, original.Pages[0].Regions)
+	}
+}
+
+func TestParseRotate(t *testing.T) {
+	for value, want := range map[string]int{"false": 0, "true": 90, "0": 0, "90": 90, "180": 180, "270": 270, "-90": 270} {
+		regions, err := ParseAtlasFile(strings.NewReader("a.png\nr\n  bounds: 0,0,4,2\n  rotate: " + value + "\n"))
+		if err != nil {
+			t.Errorf("rotate %s: %v", value, err)
+			continue
+		}
+		if got := regions[RegionKey{"r", -1}].Orientation; got != (orientation.Orientation{Rotate: want}) {
+			t.Errorf("rotate %s: got %v, want rotated %d", value, got, want)
+		}
+	}
+	for _, value := range []string{"45", "yes"} {
+		if _, err := ParseAtlasFile(strings.NewReader("a.png\nr\n  bounds: 0,0,4,2\n  rotate: " + value + "\n")); err == nil {
+			t.Errorf("rotate %s: expected an error", value)
+		}
+	}
+
+	// rotations are written as they were read, flips can't be
+	var buf bytes.Buffer
+	regions := []OutputRegion{{Name: "r", Index: -1, Bounds: image.Rect(0, 0, 4, 2), Orientation: orientation.Orientation{Rotate: 270}}}
+	if err := WriteAtlasFile(&buf, []OutputPage{{Name: "a.png", Regions: regions}}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "rotate: 270\n") {
+		t.Errorf("rotation not written:\n%s", buf.String())
+	}
+	regions[0].Orientation.Flip = true
+	if err := WriteAtlasFile(&buf, []OutputPage{{Name: "a.png", Regions: regions}}); err == nil {
+		t.Error("expected an error writing a flipped region")
+	}
+}
@@ -0,0 +1,97 @@
+package atlas
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// the atlases used by other tests, as seeds
+func addSeeds(f *testing.F) {
+	for _, seed := range []string{
+		multiPageAtlas, legacyAtlas, problemAtlas,
+		"", "\n", "a.png", "a.png\nr\n  bounds: 0,0,1,1\n  rotate: 270\n", "a.png\nr\n  bounds: 9223372036854775807,0,1,1\n",
+		"a.png\nr\n  xy: 1,2\n  size: 3,4\n  orig: 5,6\n  offset: 1,1\n  index: 3\n",
+	} {
+		f.Add(seed)
+	}
+}
+
+// parsing never panics, and Validate reports a problem whenever Parse fails
+func FuzzParseAtlasFile(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data string) {
+		_, err := ParseAtlasFile(strings.NewReader(data))
+		a, problems := Validate(strings.NewReader(data))
+		if err != nil && len(problems) == 0 {
+			t.Errorf("Parse failed with %v, but Validate found no problems", err)
+		}
+		for _, p := range problems {
+			if p.Line < 1 {
+				t.Errorf("problem without a line: %v", p)
+			}
+		}
+		for _, page := range a.Pages {
+			page.CheckBounds(image.Pt(16, 16))
+		}
+	})
+}
+
+// whatever parses can be written and parsed again, unchanged
+func FuzzWriteRoundTrip(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data string) {
+		original, err := Parse(strings.NewReader(data))
+		if err != nil {
+			return
+		}
+		pages := make([]OutputPage, len(original.Pages))
+		for i, page := range original.Pages {
+			pages[i] = OutputPage{Name: page.Name, Size: page.Size, PMA: page.PMA}
+			for _, r := range page.Regions {
+				if isImageFilename(r.Name) {
+					// written, it would name a page
+					t.Skip()
+				}
+				pages[i].Regions = append(pages[i].Regions, OutputRegion{
+					Name: r.Name, Index: r.Index, Bounds: r.Rectangle, Orientation: r.Orientation,
+					Orig: r.Orig, Offset: r.Offset, Split: r.Split, Pad: r.Pad,
+				})
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := WriteAtlasFile(&buf, pages); err != nil {
+			t.Fatal(err)
+		}
+		written, err := Parse(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%v, parsing:\n%s", err, buf.String())
+		}
+		if len(written.Pages) != len(original.Pages) {
+			t.Fatalf("%d pages written, %d read back:\n%s", len(original.Pages), len(written.Pages), buf.String())
+		}
+		for i, page := range written.Pages {
+			want := original.Pages[i]
+			if page.Name != want.Name || page.Size != want.Size || page.PMA != want.PMA {
+				t.Errorf("page %d: got %s %v pma %v, want %s %v pma %v", i, page.Name, page.Size, page.PMA, want.Name, want.Size, want.PMA)
+			}
+			if len(page.Regions) != len(want.Regions) {
+				t.Fatalf("page %d: %d regions written, %d read back:\n%s", i, len(want.Regions), len(page.Regions), buf.String())
+			}
+			for j, got := range page.Regions {
+				expected := want.Regions[j]
+				got.Line, expected.Line = 0, 0
+				if expected.Orig == (image.Point{}) {
+					// the writer takes no original size to mean untrimmed
+					expected.Orig = expected.Size()
+				}
+				if !reflect.DeepEqual(got, expected) {
+					t.Errorf("page %d region %d: got %#v, want %#v", i, j, got, expected)
+				}
+			}
+		}
+	})
+}
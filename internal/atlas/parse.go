@@ -0,0 +1,386 @@
+package atlas
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/crimro-se/atlas-repacker/internal/orientation"
+)
+
+// a problem found in an atlas file, on a line counting from 1
+type Diagnostic struct {
+	Line    int
+	Message string
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("line %d: %s", d.Line, d.Message)
+}
+
+// the page properties and region attributes understood
+var (
+	pageProperties   = map[string]bool{"size": true, "format": true, "filter": true, "repeat": true, "pma": true, "scale": true}
+	regionAttributes = map[string]bool{
+		"bounds": true, "xy": true, "size": true, "rotate": true, "offsets": true,
+		"orig": true, "offset": true, "index": true, "split": true, "pad": true,
+	}
+)
+
+// parse atlas file data, in the Spine 4 or libGDX format, keeping track of which page each region is on.
+// as libGDX does, the first line of the file or after a blank line names a page, whose properties follow.
+// every other line without a colon names a region. lines naming an image file are always pages.
+// unknown properties, repeated names and the like are tolerated, see Validate.
+func Parse(data io.Reader) (*Atlas, error) {
+	a, problems := parse(data, false)
+	if len(problems) > 0 {
+		return nil, problems[0]
+	}
+	return a, nil
+}
+
+// parses as Parse does, but rather than stopping at the first error, reports every problem found.
+// this includes what Parse tolerates: unknown or repeated properties, repeated page or region names,
+// empty regions, trims which don't fit their original size and regions outside their page's declared size.
+// the atlas returned holds every region which could be parsed.
+func Validate(data io.Reader) (*Atlas, []Diagnostic) {
+	return parse(data, true)
+}
+
+// an attribute as read, along with its line
+type attribute struct {
+	value string
+	line  int
+}
+
+// a region whose attributes are still being read
+type pendingRegion struct {
+	name  string
+	line  int
+	attrs map[string]attribute
+}
+
+type parser struct {
+	strict    bool // also report what Parse tolerates
+	atlas     Atlas
+	page      *Page             // the current page, nil between pages
+	pageProps map[string]int    // the line each of the current page's properties was given on
+	region    *pendingRegion    // the current region, nil if none
+	pages     map[string]int    // the line each page was named on
+	regions   map[RegionKey]int // the line each region was named on
+	problems  []Diagnostic
+}
+
+func parse(data io.Reader, strict bool) (*Atlas, []Diagnostic) {
+	p := parser{strict: strict, pages: make(map[string]int), regions: make(map[RegionKey]int)}
+	scanner := bufio.NewScanner(data)
+	n := 0
+	for scanner.Scan() {
+		n++
+		p.parseLine(n, strings.TrimSpace(scanner.Text()))
+	}
+	if err := scanner.Err(); err != nil {
+		p.report(n+1, "unreadable: %v", err)
+	}
+	p.endRegion()
+	return &p.atlas, p.problems
+}
+
+func (p *parser) report(line int, format string, args ...any) {
+	p.problems = append(p.problems, Diagnostic{Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// reports a problem Parse tolerates, only when validating
+func (p *parser) warn(line int, format string, args ...any) {
+	if p.strict {
+		p.report(line, format, args...)
+	}
+}
+
+func (p *parser) parseLine(n int, line string) {
+	if len(line) == 0 {
+		p.endRegion()
+		p.page = nil
+		return
+	}
+
+	key, value, isAttr := strings.Cut(line, ":")
+	if !isAttr {
+		p.endRegion()
+		if p.page == nil || isImageFilename(line) {
+			p.startPage(n, line)
+		} else {
+			p.region = &pendingRegion{name: line, line: n, attrs: make(map[string]attribute)}
+		}
+		return
+	}
+
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	switch {
+	case p.page == nil:
+		p.warn(n, "%s is outside of any page", key)
+	case p.region != nil:
+		if first, repeated := p.region.attrs[key]; repeated {
+			p.warn(n, "%s repeats for region '%s', first given on line %d", key, p.region.name, first.line)
+		}
+		if !regionAttributes[key] {
+			p.warn(n, "unknown attribute %s for region '%s'", key, p.region.name)
+		}
+		p.region.attrs[key] = attribute{value: value, line: n}
+	default:
+		p.pageProperty(n, key, value)
+	}
+}
+
+func (p *parser) startPage(n int, name string) {
+	if first, repeated := p.pages[name]; repeated {
+		p.warn(n, "page %s repeats, first named on line %d", name, first)
+	} else {
+		p.pages[name] = n
+	}
+	p.atlas.Pages = append(p.atlas.Pages, Page{Name: name, Scale: 1, Line: n})
+	p.page = &p.atlas.Pages[len(p.atlas.Pages)-1]
+	p.pageProps = make(map[string]int)
+}
+
+// sets a page header property
+func (p *parser) pageProperty(n int, key, value string) {
+	page := p.page
+	if first, repeated := p.pageProps[key]; repeated {
+		p.warn(n, "%s repeats for page %s, first given on line %d", key, page.Name, first)
+	}
+	p.pageProps[key] = n
+
+	var err error
+	switch key {
+	case "size":
+		page.Size.X, page.Size.Y, err = parse2Ints(value)
+		if err == nil && (page.Size.X <= 0 || page.Size.Y <= 0) {
+			p.warn(n, "size of page %s should be positive", page.Name)
+		}
+	case "format":
+		page.Format = value
+	case "filter":
+		page.Filter = value
+	case "repeat":
+		page.Repeat = value
+	case "pma":
+		page.PMA, err = strconv.ParseBool(value)
+	case "scale":
+		page.Scale, err = strconv.ParseFloat(value, 64)
+	default:
+		p.warn(n, "unknown property %s for page %s", key, page.Name)
+	}
+	if err != nil {
+		p.report(n, "invalid %s for page %s: %v", key, page.Name, err)
+	}
+}
+
+// adds the current region to its page, if it's valid
+func (p *parser) endRegion() {
+	pending := p.region
+	if pending == nil {
+		return
+	}
+	p.region = nil
+	region, ok := p.buildRegion(pending)
+	if !ok {
+		return
+	}
+
+	if first, repeated := p.regions[region.Key()]; repeated {
+		p.warn(region.Line, "region %v repeats, first named on line %d", region.Key(), first)
+	} else {
+		p.regions[region.Key()] = region.Line
+	}
+	if region.Empty() {
+		p.warn(region.Line, "region %v is empty", region.Key())
+	}
+	if region.Trimmed() && !region.TrimmedRect().In(image.Rectangle{Max: region.Orig}) {
+		p.warn(region.Line, "region %v doesn't fit within its original size %dx%d at offset %d,%d",
+			region.Key(), region.Orig.X, region.Orig.Y, region.Offset.X, region.Offset.Y)
+	}
+	if p.page.Size != (image.Point{}) && !region.StoredRect().In(image.Rectangle{Max: p.page.Size}) {
+		p.warn(region.Line, "region %v %v lies outside the size of page %s", region.Key(), region.StoredRect(), p.page.Name)
+	}
+	p.page.Regions = append(p.page.Regions, region)
+}
+
+// builds a region from its attributes, in either the Spine 4 form (bounds, offsets)
+// or the legacy libGDX form (xy, size, orig, offset). returns false if any are invalid.
+func (p *parser) buildRegion(pending *pendingRegion) (Region, bool) {
+	region := Region{Name: pending.name, Index: -1, Line: pending.line}
+	ok := true
+	// parses the attribute key with parse if present, reporting it if invalid
+	get := func(key string, parse func(value string) error) bool {
+		attr, present := pending.attrs[key]
+		if !present {
+			return false
+		}
+		if err := parse(attr.value); err != nil {
+			p.report(attr.line, "invalid %s for region '%s': %v", key, pending.name, err)
+			ok = false
+		}
+		return true
+	}
+	// parses a size, which can't be negative
+	parseSize := func(value string, size *image.Point) error {
+		var err error
+		if size.X, size.Y, err = parse2Ints(value); err == nil && (size.X < 0 || size.Y < 0) {
+			err = errors.New("negative size")
+		}
+		return err
+	}
+
+	var o orientation.Orientation
+	get("rotate", func(value string) (err error) {
+		o, err = parseRotate(value)
+		return err
+	})
+
+	var pos, size image.Point
+	hasBounds := get("bounds", func(value string) error {
+		var err error
+		if pos.X, pos.Y, size.X, size.Y, err = parse4Ints(value); err == nil && (size.X < 0 || size.Y < 0) {
+			err = errors.New("negative size")
+		}
+		return err
+	})
+	if xy, hasXY := pending.attrs["xy"]; hasXY && hasBounds {
+		p.warn(xy.line, "region '%s' has both bounds and xy, xy is ignored", pending.name)
+	} else if hasXY {
+		if _, hasSize := pending.attrs["size"]; !hasSize {
+			p.report(xy.line, "region '%s' has xy, but size is missing", pending.name)
+			ok = false
+		}
+		get("xy", func(value string) (err error) {
+			pos.X, pos.Y, err = parse2Ints(value)
+			return err
+		})
+		get("size", func(value string) error { return parseSize(value, &size) })
+	} else if !hasBounds {
+		p.report(pending.line, "region '%s' has neither bounds, nor xy and size", pending.name)
+		ok = false
+	}
+	if ok && (pos.X > math.MaxInt-size.X || pos.Y > math.MaxInt-size.Y) {
+		p.report(pending.line, "region '%s' extends beyond the largest coordinate", pending.name)
+		ok = false
+	}
+	region.RotatableRect = buildRect(pos.X, pos.Y, size.X, size.Y, o)
+	region.Orig = size
+
+	get("offsets", func(value string) error {
+		var err error
+		region.Offset.X, region.Offset.Y, region.Orig.X, region.Orig.Y, err = parse4Ints(value)
+		return err
+	})
+	get("orig", func(value string) error { return parseSize(value, &region.Orig) })
+	get("offset", func(value string) (err error) {
+		region.Offset.X, region.Offset.Y, err = parse2Ints(value)
+		return err
+	})
+	get("index", func(value string) (err error) {
+		// any negative index means the region isn't an animation frame
+		region.Index, err = strconv.Atoi(value)
+		region.Index = max(region.Index, -1)
+		return err
+	})
+	get("split", func(value string) (err error) {
+		region.Split, err = parseIntList(value, 4)
+		return err
+	})
+	get("pad", func(value string) (err error) {
+		region.Pad, err = parseIntList(value, 4)
+		return err
+	})
+	return region, ok
+}
+
+// true if name has the extension of an image format atlases reference
+func isImageFilename(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png", ".webp", ".gif", ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
+
+// builds a rect that might require a deferred rotation
+func buildRect(x, y, w, h int, o orientation.Orientation) RotatableRect {
+	var r RotatableRect
+	r.Orientation = o
+	r.Rectangle = image.Rect(x, y, x+w, y+h)
+	return r
+}
+
+// parses a rotate attribute: true (90), false or degrees counter-clockwise
+func parseRotate(value string) (orientation.Orientation, error) {
+	switch value {
+	case "", "false":
+		return orientation.Upright, nil
+	case "true":
+		return orientation.Orientation{Rotate: 90}, nil
+	}
+	degrees, err := strconv.Atoi(value)
+	if err != nil {
+		return orientation.Upright, fmt.Errorf("expected true, false or degrees, not '%s'", value)
+	}
+	return orientation.Rotated(degrees)
+}
+
+func parse2Ints(str string) (int, int, error) {
+	ints, err := parseIntList(str, 2)
+	if err != nil {
+		return 0, 0, err
+	}
+	return ints[0], ints[1], nil
+}
+
+func parse4Ints(str string) (int, int, int, int, error) {
+	ints, err := parseIntList(str, 4)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	return ints[0], ints[1], ints[2], ints[3], nil
+}
+
+// parses a comma separated list of exactly count ints
+func parseIntList(str string, count int) ([]int, error) {
+	fields := strings.Split(str, ",")
+	if len(fields) != count {
+		return nil, fmt.Errorf("expected %d comma separated ints, not '%s'", count, str)
+	}
+	ints := make([]int, count)
+	for i, f := range fields {
+		v, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, fmt.Errorf("expected %d comma separated ints, not '%s'", count, str)
+		}
+		ints[i] = v
+	}
+	return ints, nil
+}
+
+// reports the page's regions which lie outside an image of the given size,
+// and a declared size which differs from it
+func (p Page) CheckBounds(size image.Point) []Diagnostic {
+	var problems []Diagnostic
+	if p.Size != (image.Point{}) && p.Size != size {
+		problems = append(problems, Diagnostic{Line: p.Line, Message: fmt.Sprintf(
+			"page %s has size %dx%d, but its image is %dx%d", p.Name, p.Size.X, p.Size.Y, size.X, size.Y)})
+	}
+	bounds := image.Rectangle{Max: size}
+	for _, r := range p.Regions {
+		if stored := r.StoredRect(); !stored.In(bounds) {
+			problems = append(problems, Diagnostic{Line: r.Line, Message: fmt.Sprintf(
+				"region %v %v lies outside the %dx%d image of page %s", r.Key(), stored, size.X, size.Y, p.Name)})
+		}
+	}
+	return problems
+}
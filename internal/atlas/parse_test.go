@@ -0,0 +1,114 @@
+package atlas
+
+import (
+	"image"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// every problem is reported on its own line, including those Parse tolerates
+const problemAtlas = `a.png
+size: 32,32
+bogus: 1
+first
+  bounds: 0,0,8,8
+  bounds: 0,0,8,8
+  colour: red
+first
+  bounds: 8,0,8,8
+outside
+  bounds: 30,30,8,8
+trim
+  bounds: 0,8,8,8
+  offsets: 4,4,10,10
+broken
+  bounds: 1,2,3
+both
+  bounds: 0,16,4,4
+  xy: 0,16
+nothing
+  rotate: false
+`
+
+func TestValidate(t *testing.T) {
+	a, problems := Validate(strings.NewReader(problemAtlas))
+	var lines []int
+	for _, p := range problems {
+		lines = append(lines, p.Line)
+	}
+	if want := []int{3, 6, 7, 8, 10, 12, 16, 19, 20}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("problems on lines %v, want %v: %v", lines, want, problems)
+	}
+	// regions which couldn't be parsed are left out
+	if len(a.Pages) != 1 || len(a.Pages[0].Regions) != 5 {
+		t.Errorf("unexpected pages %+v", a.Pages)
+	}
+
+	// Parse only stops at what it can't make sense of
+	_, err := Parse(strings.NewReader(problemAtlas))
+	if d, ok := err.(Diagnostic); !ok || d.Line != 16 {
+		t.Errorf("expected an error on line 16, got %v", err)
+	}
+}
+
+func TestValidateClean(t *testing.T) {
+	for _, data := range []string{multiPageAtlas, legacyAtlas} {
+		if _, problems := Validate(strings.NewReader(data)); len(problems) > 0 {
+			t.Errorf("unexpected problems %v", problems)
+		}
+	}
+}
+
+func TestCheckBounds(t *testing.T) {
+	a, err := Parse(strings.NewReader(multiPageAtlas))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := a.Pages[0].CheckBounds(image.Pt(128, 64)); len(problems) > 0 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+
+	// body is stored rotated, so occupies 40x30 from 10,0
+	problems := a.Pages[0].CheckBounds(image.Pt(45, 25))
+	var lines []int
+	for _, p := range problems {
+		lines = append(lines, p.Line)
+	}
+	if want := []int{2, 11}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("problems on lines %v, want %v: %v", lines, want, problems)
+	}
+}
+
+func TestParseIndex(t *testing.T) {
+	for value, want := range map[string]int{"3": 3, "0": 0, "-1": -1, "-5": -1} {
+		a, err := Parse(strings.NewReader("a.png\nr\n  bounds: 0,0,1,1\n  index: " + value + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// any negative index means the region isn't an animation frame
+		if got := a.Pages[0].Regions[0].Index; got != want {
+			t.Errorf("index %s: got %d, want %d", value, got, want)
+		}
+	}
+}
+
+// bounds whose far edge can't be represented are refused, rather than wrapping around
+func TestParseOverflow(t *testing.T) {
+	largest := strconv.Itoa(math.MaxInt)
+	for _, data := range []string{
+		"a.png\nr\n  bounds: " + largest + ",0,1,1\n",
+		"a.png\nr\n  bounds: 1,0," + largest + ",1\n",
+		"a.png\nr\n  xy: 0," + largest + "\n  size: 1,1\n",
+	} {
+		_, err := Parse(strings.NewReader(data))
+		if d, ok := err.(Diagnostic); !ok || d.Line != 2 {
+			t.Errorf("expected an error on line 2 parsing %q, got %v", data, err)
+		}
+	}
+	if _, err := Parse(strings.NewReader("a.png\nr\n  bounds: " + strconv.Itoa(math.MaxInt-1) + ",0,1,1\n")); err != nil {
+		t.Errorf("the largest coordinate is refused: %v", err)
+	}
+}
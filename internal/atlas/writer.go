@@ -0,0 +1,79 @@
+package atlas
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/crimro-se/atlas-repacker/internal/orientation"
+)
+
+// a single page (image) of an atlas to be written out
+type OutputPage struct {
+	Name    string      // filename of the page image, as referenced by the atlas
+	Size    image.Point // pixel dimensions of the page image
+	PMA     bool        // true if the page image's colours are premultiplied by alpha
+	Regions []OutputRegion
+}
+
+// a region on an output page
+type OutputRegion struct {
+	Name        string
+	Index       int                     // frame number within an animation whose frames share Name, -1 if it isn't one
+	Bounds      image.Rectangle         // location on the page, in unrotated (logical) dimensions
+	Orientation orientation.Orientation // how the pixels on the page are stored. The format can't describe flips
+	Orig        image.Point             // size of the original image before trimming, zero if untrimmed
+	Offset      image.Point             // position of Bounds within the original image, from its bottom left
+	Split       []int                   // nine-patch split lines, nil if not a nine-patch
+	Pad         []int                   // nine-patch content padding, nil if not given
+}
+
+// writes pages in the Spine 4 / libGDX atlas format. nothing is written if a region can't be described.
+func WriteAtlasFile(w io.Writer, pages []OutputPage) error {
+	for _, page := range pages {
+		for _, r := range page.Regions {
+			if r.Orientation.Flip {
+				return fmt.Errorf("error whilst writing atlas, region '%s' is flipped, which the format can't describe", r.Name)
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	for i, page := range pages {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		fmt.Fprintln(bw, page.Name)
+		fmt.Fprintf(bw, "size: %d,%d\n", page.Size.X, page.Size.Y)
+		fmt.Fprintln(bw, "format: RGBA8888")
+		fmt.Fprintln(bw, "filter: Linear,Linear")
+		fmt.Fprintln(bw, "repeat: none")
+		if page.PMA {
+			fmt.Fprintln(bw, "pma: true")
+		}
+		for _, r := range page.Regions {
+			w, h := r.Bounds.Dx(), r.Bounds.Dy()
+			orig := r.Orig
+			if orig == (image.Point{}) {
+				orig = r.Bounds.Size()
+			}
+			fmt.Fprintln(bw, r.Name)
+			if r.Index >= 0 {
+				fmt.Fprintf(bw, "  index: %d\n", r.Index)
+			}
+			fmt.Fprintf(bw, "  bounds: %d,%d,%d,%d\n", r.Bounds.Min.X, r.Bounds.Min.Y, w, h)
+			if r.Orientation.Rotate != 0 {
+				fmt.Fprintf(bw, "  rotate: %d\n", r.Orientation.Rotate)
+			}
+			fmt.Fprintf(bw, "  offsets: %d,%d,%d,%d\n", r.Offset.X, r.Offset.Y, orig.X, orig.Y)
+			if len(r.Split) == 4 {
+				fmt.Fprintf(bw, "  split: %d,%d,%d,%d\n", r.Split[0], r.Split[1], r.Split[2], r.Split[3])
+			}
+			if len(r.Pad) == 4 {
+				fmt.Fprintf(bw, "  pad: %d,%d,%d,%d\n", r.Pad[0], r.Pad[1], r.Pad[2], r.Pad[3])
+			}
+		}
+	}
+	return bw.Flush()
+}
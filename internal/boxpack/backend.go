@@ -0,0 +1,8 @@
+//go:build !(stb && cgo)
+
+package boxpack
+
+// packs rects in slice order, on the skyline implementation chosen by build tags
+func skylineBackend(rects []packRect, W, H int, heuristic SkylineHeuristic) {
+	skylinePackRects(rects, W, H, heuristic)
+}
@@ -0,0 +1,59 @@
+//go:build stb && cgo
+
+package boxpack
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// builds a shared corpus of rect sets for comparing packing backends
+func backendCorpus() [][]packRect {
+	rng := rand.New(rand.NewSource(1))
+	corpus := make([][]packRect, 0)
+	for _, n := range []int{1, 2, 17, 100, 1000} {
+		for _, maxSide := range []int{4, 30, 200} {
+			rects := make([]packRect, n)
+			for i := range rects {
+				rects[i].id = i
+				rects[i].w = rng.Intn(maxSide)
+				rects[i].h = rng.Intn(maxSide)
+			}
+			corpus = append(corpus, rects)
+		}
+	}
+	// many identical boxes, where an unstable sort would shuffle ids
+	same := make([]packRect, 300)
+	for i := range same {
+		same[i] = packRect{id: i, w: 11, h: 11}
+	}
+	return append(corpus, same)
+}
+
+func TestBackendsMatch(t *testing.T) {
+	sorts, _ := SortOrdersByName("all")
+	for ci, corpus := range backendCorpus() {
+		for _, order := range sorts {
+			rects := make([]packRect, len(corpus))
+			copy(rects, corpus)
+			sortRects(rects, order)
+			for _, wh := range [][2]int{{64, 64}, {512, 128}, {1024, 1024}} {
+				for _, heuristic := range []SkylineHeuristic{SkylineBL, SkylineBF} {
+					goRects := make([]packRect, len(rects))
+					copy(goRects, rects)
+					stbRects := make([]packRect, len(rects))
+					copy(stbRects, rects)
+					skylinePackRects(goRects, wh[0], wh[1], heuristic)
+					stbPackRects(stbRects, wh[0], wh[1], heuristic)
+					for i := range goRects {
+						g, s := goRects[i], stbRects[i]
+						if g.wasPacked != s.wasPacked || (g.wasPacked && (g.x != s.x || g.y != s.y)) {
+							t.Fatalf("corpus %d sorted by %s, %dx%d, heuristic %s: rect %d differs. go %+v, stb %+v",
+								ci, order, wh[0], wh[1], heuristic, i, g, s)
+						}
+					}
+				}
+			}
+		}
+	}
+}
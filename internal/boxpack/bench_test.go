@@ -0,0 +1,37 @@
+package boxpack
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+// n boxes of 2 to 30 pixels a side, as islands detected from a busy sheet
+func benchBoxes(n int) []BoxTranslation {
+	rng := rand.New(rand.NewSource(1))
+	boxes := make([]BoxTranslation, n)
+	for i := range boxes {
+		boxes[i].sourceRect = image.Rect(0, 0, 2+rng.Intn(29), 2+rng.Intn(29))
+	}
+	return boxes
+}
+
+func BenchmarkFindMinSize(b *testing.B) {
+	boxes := benchBoxes(3000)
+	for _, bench := range []struct {
+		name string
+		c    SizeConstraints
+	}{
+		{"unconstrained", SizeConstraints{}},
+		{"pot", SizeConstraints{PowerOfTwo: true}},
+		{"maxaspect", SizeConstraints{MaxAspect: 2}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			var attempts int
+			for i := 0; i < b.N; i++ {
+				_, _, attempts = FindMinSize(DefaultPackConfig(), boxes, 1, bench.c)
+			}
+			b.ReportMetric(float64(attempts), "attempts")
+		})
+	}
+}
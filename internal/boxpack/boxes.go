@@ -1,100 +1,101 @@
-// package to make stb_rect_pack.h usable in Go.
+// package for packing boxes onto output sheets.
+// The packer is a native Go port of stb_rect_pack.h's skyline packer, the C original
+// remains available by building with the "stb" tag (requires cgo).
 package boxpack
 
-/*
-	#define STB_RECT_PACK_IMPLEMENTATION
-
-	#include "stb_rect_pack.h"
-	#include <stdlib.h>
-	#include <stdio.h>
-
-struct stbrp_rect* allocateRects(int n) {
-   struct stbrp_rect* array;
-
-   // Allocate memory for the array of n structs
-   array = (struct stbrp_rect*)calloc(n, sizeof(struct stbrp_rect));
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
 
-   if (array == NULL) {
-      fprintf(stderr, "Memory allocation for rects failed\n");
-      exit(EXIT_FAILURE);
-   }
+	"github.com/crimro-se/atlas-repacker/internal/orientation"
+)
 
-   return array;
+// A box translation tracks its source image number and rect,
+// its new location and if it was successfully repacked.
+type BoxTranslation struct {
+	imgSrc      int                     // which input image is this box from?
+	sourceRect  image.Rectangle         // pixel locations on original input image
+	destRect    image.Rectangle         // destination rect.
+	wasPacked   bool                    // true if this box has been successfully packed
+	orientation orientation.Orientation // how the source pixels are stored, undone when rendering
+	packRotated bool                    // the packer rotated this box, it's stored rotated on the output like an atlas "rotate: 90" region
+	mask        *image.Alpha            // optional, bounds equal sourceRect. Only opaque pixels are rendered.
 }
 
-struct stbrp_node* allocateNodes(int n) {
-   struct stbrp_node* array;
-
-   // Allocate memory for the array of n structs
-   array = (struct stbrp_node*)calloc(n, sizeof(struct stbrp_node));
-
-   if (array == NULL) {
-      fprintf(stderr, "Memory allocation for nodes failed\n");
-      exit(EXIT_FAILURE);
-   }
-
-   return array;
+// returns the sum of area required for all sourceRect boxes
+func getSourceArea(boxes []BoxTranslation, margin int) int {
+	area := 0
+	for _, box := range boxes {
+		area += (box.sourceRect.Dx() + margin) * (box.sourceRect.Dy() + margin)
+	}
+	return area
 }
 
-struct stbrp_context* allocateCTX() {
-   struct stbrp_context* array;
+// a box of r within image imgref, whose pixels are stored with orientation o.
+// r's size is upright, as the region is displayed, even if o swaps the stored width and height.
+func BoxFromRect(imgref int, r image.Rectangle, o orientation.Orientation) BoxTranslation {
+	return BoxTranslation{imgSrc: imgref, sourceRect: r, wasPacked: false, orientation: o}
+}
 
-   // Allocate memory for the array of n structs
-   array = (struct stbrp_context*)calloc(1, sizeof(struct stbrp_context));
+// a box which renders only the pixels set in mask, whose bounds must equal r.
+// Useful when other islands intrude into r.
+func BoxFromMask(imgref int, r image.Rectangle, mask *image.Alpha) BoxTranslation {
+	return BoxTranslation{imgSrc: imgref, sourceRect: r, mask: mask}
+}
 
-   if (array == NULL) {
-      fprintf(stderr, "Memory allocation for ctx failed\n");
-      exit(EXIT_FAILURE);
-   }
+// marks the box as packed, unrotated, with its destination's top left at p.
+// lets a box be rendered somewhere of the caller's choosing.
+func (b *BoxTranslation) PlaceAt(p image.Point) {
+	b.destRect = image.Rectangle{Min: p, Max: p.Add(b.sourceRect.Size())}
+	b.wasPacked, b.packRotated = true, false
+}
 
-   return array;
+// which input image this box is from
+func (b BoxTranslation) ImgSrc() int {
+	return b.imgSrc
 }
 
-// no bounds checking btw
-void assignValue(struct stbrp_rect* array, int index, struct stbrp_rect* value) {
-   array[index] = *value;
+// pixel locations on the original input image, in upright dimensions. see StoredRect
+func (b BoxTranslation) SourceRect() image.Rectangle {
+	return b.sourceRect
 }
 
-void getValue(struct stbrp_rect* array, int index, struct stbrp_rect* value) {
-   *value= array[index];
+// the pixels actually occupied on the original input image, whose dimensions
+// differ from SourceRect's if the source is stored rotated by 90 or 270 degrees.
+func (b BoxTranslation) StoredRect() image.Rectangle {
+	return image.Rectangle{Min: b.sourceRect.Min, Max: b.sourceRect.Min.Add(b.orientation.StoredSize(b.sourceRect.Size()))}
 }
 
-void myFree(void *mem){
-	free(mem);
+// how the source pixels are stored relative to upright
+func (b BoxTranslation) Orientation() orientation.Orientation {
+	return b.orientation
 }
-*/
-import "C"
-import (
-	"image"
-	"image/color"
-	"image/draw"
-	"math"
-	"unsafe"
 
-	"github.com/disintegration/imaging"
-)
+// destination rect on the output image. Only meaningful if WasPacked is true.
+// If PackRotated, the rect's dimensions are swapped relative to SourceRect.
+func (b BoxTranslation) DestRect() image.Rectangle {
+	return b.destRect
+}
 
-// A box translation tracks its source image number and rect,
-// its new location and if it was successfully repacked.
-type BoxTranslation struct {
-	imgSrc         int             // which input image is this box from?
-	sourceRect     image.Rectangle // pixel locations on original input image
-	destRect       image.Rectangle // destination rect.
-	wasPacked      bool            // true if this box has been successfully packed
-	deferredRotate bool            // rotate 90 clockwise when rendering if true
+// true if the packer rotated this box. Its pixels are then stored on the output
+// rotated 90 degrees counter-clockwise, as per an atlas region with "rotate: 90".
+func (b BoxTranslation) PackRotated() bool {
+	return b.packRotated
 }
 
-// returns the sum of area required for all sourceRect boxes
-func getSourceArea(boxes []BoxTranslation, margin int) int {
-	area := 0
-	for _, box := range boxes {
-		area += (box.sourceRect.Dx() + margin) * (box.sourceRect.Dy() + margin)
+// how the box's pixels are stored on the output relative to upright
+func (b BoxTranslation) PackOrientation() orientation.Orientation {
+	if b.packRotated {
+		return orientation.Orientation{Rotate: 90}
 	}
-	return area
+	return orientation.Upright
 }
 
-func BoxFromRect(imgref int, r image.Rectangle, rotate bool) BoxTranslation {
-	return BoxTranslation{imgSrc: imgref, sourceRect: r, wasPacked: false, deferredRotate: rotate}
+// true if this box has been successfully packed
+func (b BoxTranslation) WasPacked() bool {
+	return b.wasPacked
 }
 
 // Estimates an appropriate w & h for output based on the input squares
@@ -112,31 +113,55 @@ func EstimateOutputWH(boxes []BoxTranslation, margin int) int {
 // offset - ammount to offset each box. useful values are half of margin, =margin, or zero.
 // returns result and count of any remaining unpacked (size+margin > W or H)
 func PackAllBoxes(boxesImmutable []BoxTranslation, W, H, boxMargin, offset int) ([][]BoxTranslation, int) {
-	allBoxes := make([][]BoxTranslation, 0)
 	boxes := make([]BoxTranslation, len(boxesImmutable)) // working copy
 	copy(boxes, boxesImmutable)
-	i := 0
-	unpacked := len(boxes)
-	for unpacked > 0 {
-		previous := unpacked
-		unpacked = PackBoxes(boxes, W, H, boxMargin, offset)
-		if previous == unpacked {
-			// no progress since previous iteration
+	pages, unpacked := PackPages(DefaultPackConfig(), boxes, W, H, boxMargin, offset, 0)
+	allBoxes := make([][]BoxTranslation, len(pages))
+	for i, page := range pages {
+		allBoxes[i] = make([]BoxTranslation, 0, len(page))
+		for _, id := range page {
+			allBoxes[i] = append(allBoxes[i], boxes[id])
+		}
+	}
+	return allBoxes, unpacked
+}
+
+// Packs the boxes parameter in-place across multiple output sheets, each W x H.
+// Each box's destRect is relative to the sheet it was placed on.
+// cfg - as per PackBoxesWith, the best packer is chosen for each sheet.
+// maxPages - maximum number of sheets to use, or 0 for no limit.
+// returns, for each sheet, the indices of the boxes placed on it and the count of any remaining unpacked.
+func PackPages(cfg PackConfig, boxes []BoxTranslation, W, H, boxMargin, offset, maxPages int) ([][]int, int) {
+	pages := make([][]int, 0)
+	remaining := make([]int, len(boxes))
+	for i := range remaining {
+		remaining[i] = i
+	}
+	work := make([]BoxTranslation, 0, len(boxes))
+	for len(remaining) > 0 && (maxPages < 1 || len(pages) < maxPages) {
+		work = work[:0]
+		for _, id := range remaining {
+			work = append(work, boxes[id])
+		}
+		unpacked, _ := PackBoxesWith(cfg, work, W, H, boxMargin, offset)
+		if unpacked == len(work) {
+			// no progress, nothing left will ever fit
 			break
 		}
-		remainer := make([]BoxTranslation, 0, unpacked)
-		allBoxes = append(allBoxes, make([]BoxTranslation, 0, previous-unpacked))
-		for _, box := range boxes {
-			if box.wasPacked {
-				allBoxes[i] = append(allBoxes[i], box)
+		page := make([]int, 0, len(work)-unpacked)
+		next := make([]int, 0, unpacked)
+		for i, id := range remaining {
+			boxes[id] = work[i]
+			if work[i].wasPacked {
+				page = append(page, id)
 			} else {
-				remainer = append(remainer, box)
+				next = append(next, id)
 			}
 		}
-		boxes = remainer
-		i++
+		pages = append(pages, page)
+		remaining = next
 	}
-	return allBoxes, unpacked
+	return pages, len(remaining)
 }
 
 // Packs the boxes parameter in-place, updating destRect and wasPacked accordingly
@@ -146,62 +171,48 @@ func PackAllBoxes(boxesImmutable []BoxTranslation, W, H, boxMargin, offset int)
 // nb: although this looks like boxes is passed by-value, a slice type is just accounting ints and a ptr to its own data.
 // extra dereferencing wouldn't benefit us as we don't append or remove from the slice.
 func PackBoxes(boxes []BoxTranslation, W, H, boxMargin, offset int) int {
-	stbr := C.allocateRects(C.int(len(boxes)))
-	defer C.myFree(unsafe.Pointer(stbr))
-	boxesToSTBR(boxes, stbr, boxMargin)
-	ctx := C.allocateCTX()
-	defer C.myFree(unsafe.Pointer(ctx))
-	nodeCount := max(512, W, len(boxes))
-	nodes := C.allocateNodes(C.int(nodeCount))
-	defer C.myFree(unsafe.Pointer(nodes))
-	C.stbrp_init_target(ctx, C.int(W), C.int(H), nodes, C.int(nodeCount))
-	C.stbrp_pack_rects(ctx, stbr, C.int(len(boxes)))
-
-	var box C.stbrp_rect
-	var unpacked int
-	for i := 0; i < len(boxes); i++ {
-		C.getValue(stbr, C.int(i), &box)
-		if box.was_packed > 0 {
-			id := int(box.id)
-			boxes[id].wasPacked = true
-			w, h := boxes[id].sourceRect.Dx(), boxes[id].sourceRect.Dy()
-			boxes[id].destRect.Min.X = int(box.x) + offset
-			boxes[id].destRect.Min.Y = int(box.y) + offset
-			boxes[id].destRect.Max.X = boxes[id].destRect.Min.X + w
-			boxes[id].destRect.Max.Y = boxes[id].destRect.Min.Y + h
-		} else {
-			unpacked++
-		}
-	}
+	unpacked, _ := PackBoxesWith(DefaultPackConfig(), boxes, W, H, boxMargin, offset)
 	return unpacked
 }
 
 // Creates a new image based on the input images and packed boxes.
 // typically used after ImageToBoxes and PackBoxes
+// source rects are in their image's own coordinates, dest rects are relative to outImg's top left.
 func RenderAll(images []image.Image, boxes []BoxTranslation, outImg draw.Image) {
 	dx, dy := getMaxSourceRectSizes(boxes)
 	maxSide := max(dx, dy)
 	nrgba := image.NewNRGBA(image.Rect(0, 0, maxSide, maxSide))
+	origin := outImg.Bounds().Min
 	for _, box := range boxes {
-		if box.deferredRotate {
-			// this has the bizzare implication that the source W & H need to be swapped first.
-			// we left them "wrong" prior to packing in order to produce a correct destination rect
-			box.sourceRect.Max = image.Point{
-				X: box.sourceRect.Min.X + box.sourceRect.Dy(),
-				Y: box.sourceRect.Min.Y + box.sourceRect.Dx(),
-			}
+		if !box.wasPacked {
+			continue
+		}
+		destRect := box.destRect.Add(origin)
+		// undo how the source is stored, then store it as the output wants
+		transform := box.orientation.Inverse().Then(box.PackOrientation())
+		if transform == orientation.Upright {
+			// either no rotation at all, or the source is already stored as we want on output
+			drawMasked(outImg, destRect, images[box.imgSrc], box.sourceRect.Min, box.mask)
+			continue
+		}
 
-			// rotation
-			bufferRect := image.Rect(0, 0, box.sourceRect.Dx(), box.sourceRect.Dy())
-			draw.Draw(nrgba, bufferRect, images[box.imgSrc], box.sourceRect.Min, draw.Src)
-			croppedBuffer := nrgba.SubImage(bufferRect)
-			rotatedImage := imaging.Rotate270(croppedBuffer)
+		// the source rect has upright dimensions, the stored pixels may not
+		physicalRect := box.StoredRect()
+		bufferRect := image.Rect(0, 0, physicalRect.Dx(), physicalRect.Dy())
+		drawMasked(nrgba, bufferRect, images[box.imgSrc], physicalRect.Min, box.mask)
+		transformed := transform.Apply(nrgba.SubImage(bufferRect))
+		draw.Draw(outImg, destRect, transformed, image.Point{0, 0}, draw.Src)
+	}
+}
 
-			draw.Draw(outImg, box.destRect, rotatedImage, image.Point{0, 0}, draw.Src)
-		} else {
-			draw.Draw(outImg, box.destRect, images[box.imgSrc], box.sourceRect.Min, draw.Src)
-		}
+// copies src to r within dst as draw.Draw does. If mask is non-nil, pixels outside it are left transparent.
+// masks only exist for unrotated sources, so mask and src share coordinates.
+func drawMasked(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point, mask *image.Alpha) {
+	if mask == nil {
+		draw.Draw(dst, r, src, sp, draw.Src)
+		return
 	}
+	draw.DrawMask(dst, r, src, sp, mask, sp, draw.Src)
 }
 
 // returns the maximum width and heights represented in the set of source rects.
@@ -214,23 +225,10 @@ func getMaxSourceRectSizes(boxes []BoxTranslation) (int, int) {
 	return dx, dy
 }
 
-/*
-Converts a slice of Box into a C array of stbrp_rect via the dimensions of box.sourceRect
-stbr pointer is presumed to point to an array of sufficient size.
-*/
-func boxesToSTBR(boxes []BoxTranslation, stbr *C.stbrp_rect, margin int) {
-	var box C.stbrp_rect
-	for i := 0; i < len(boxes); i++ {
-		box.id = C.int(i)
-		box.w = C.int(boxes[i].sourceRect.Dx() + margin)
-		box.h = C.int(boxes[i].sourceRect.Dy() + margin)
-		C.assignValue(stbr, C.int(i), &box)
-	}
-}
-
 // draws all of either the source or destination set of rects in a []BoxTranslation onto a new RGBA image.
-func DebugViewRects(boxes []BoxTranslation, W, H int, drawSrcRects bool, imgSrc int) image.Image {
-	img := image.NewRGBA64(image.Rect(0, 0, W, H))
+// bounds should be those of the source image, or the output's when drawing destination rects.
+func DebugViewRects(boxes []BoxTranslation, bounds image.Rectangle, drawSrcRects bool, imgSrc int) image.Image {
+	img := image.NewRGBA64(bounds)
 	rectCol := image.NewUniform(color.RGBA{255, 255, 255, 255})
 
 	for _, b := range boxes {
@@ -238,9 +236,9 @@ func DebugViewRects(boxes []BoxTranslation, W, H int, drawSrcRects bool, imgSrc
 			continue
 		}
 		if drawSrcRects {
-			draw.Draw(img, b.sourceRect, rectCol, image.ZP, draw.Src)
+			draw.Draw(img, b.StoredRect(), rectCol, image.ZP, draw.Src)
 		} else {
-			draw.Draw(img, b.destRect, rectCol, image.ZP, draw.Src)
+			draw.Draw(img, b.destRect.Add(bounds.Min), rectCol, image.ZP, draw.Src)
 		}
 	}
 
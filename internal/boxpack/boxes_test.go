@@ -1,8 +1,14 @@
 package boxpack
 
 import (
+	"bytes"
 	"image"
+	"image/color"
+	"math/rand"
 	"testing"
+
+	"github.com/crimro-se/atlas-repacker/internal/orientation"
+	"github.com/disintegration/imaging"
 )
 
 func TestPacking(t *testing.T) {
@@ -55,3 +61,257 @@ func TestPacking(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestPackPages(t *testing.T) {
+	var boxes []BoxTranslation
+	for i := 0; i < 3; i++ {
+		boxes = append(boxes, BoxTranslation{sourceRect: image.Rect(0, 0, 10, 10)})
+	}
+	boxes = append(boxes, BoxTranslation{sourceRect: image.Rect(0, 0, 20, 20)})
+
+	pages, unpacked := PackPages(DefaultPackConfig(), boxes, 11, 11, 1, 0, 0)
+	if len(pages) != 3 || unpacked != 1 {
+		t.Fatalf("expected 3 pages and 1 unpacked, got %d and %d", len(pages), unpacked)
+	}
+	for _, page := range pages {
+		if len(page) != 1 || !boxes[page[0]].wasPacked {
+			t.Fail()
+		}
+	}
+
+	pages, unpacked = PackPages(DefaultPackConfig(), boxes, 11, 11, 1, 0, 2)
+	if len(pages) != 2 || unpacked != 2 {
+		t.Fatalf("expected 2 pages and 2 unpacked, got %d and %d", len(pages), unpacked)
+	}
+
+	all, unpacked := PackAllBoxes(boxes, 11, 11, 1, 0)
+	if len(all) != 3 || unpacked != 1 {
+		t.Fail()
+	}
+}
+
+func TestPackers(t *testing.T) {
+	var boxes []BoxTranslation
+	for _, wh := range [][2]int{{10, 10}, {20, 10}, {10, 20}, {30, 30}, {3, 40}, {40, 3}, {50, 50}} {
+		for i := 0; i < 4; i++ {
+			boxes = append(boxes, BoxTranslation{sourceRect: image.Rect(0, 0, wh[0], wh[1])})
+		}
+	}
+	packers, err := PackersByName("all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cfg := range packerConfigs(packers) {
+		p := cfg.Packers[0]
+		unpacked, _ := PackBoxesWith(cfg, boxes, 200, 200, 1, 0)
+		if unpacked != 0 {
+			t.Errorf("%s: %d boxes unpacked", p.Name(), unpacked)
+		}
+		for i, a := range boxes {
+			if !a.destRect.In(image.Rect(0, 0, 200, 200)) {
+				t.Errorf("%s: box %d out of bounds at %v", p.Name(), i, a.destRect)
+			}
+			for j := i + 1; j < len(boxes); j++ {
+				if a.destRect.Overlaps(boxes[j].destRect) {
+					t.Errorf("%s: boxes %d and %d overlap", p.Name(), i, j)
+				}
+			}
+		}
+	}
+	if unpacked, _ := PackBoxesWith(PackConfig{Packers: packers}, boxes, 200, 200, 1, 0); unpacked != 0 {
+		t.Fail()
+	}
+}
+
+// every packer and sort order individually, with and without rotation
+func packerConfigs(packers []Packer) []PackConfig {
+	sorts, _ := SortOrdersByName("all")
+	configs := make([]PackConfig, 0, len(packers)*len(sorts)*2)
+	for _, p := range append(packers, SkylinePacker{Heuristic: SkylineBF}) {
+		for _, s := range sorts {
+			configs = append(configs, PackConfig{Packers: []Packer{p}, Sorts: []SortOrder{s}})
+			configs = append(configs, PackConfig{Packers: []Packer{p}, Sorts: []SortOrder{s}, AllowRotate: true})
+		}
+	}
+	return configs
+}
+
+func TestPackRotation(t *testing.T) {
+	// a tall box that only fits a wide sheet when rotated
+	boxes := []BoxTranslation{{sourceRect: image.Rect(0, 0, 10, 40)}}
+	packers, _ := PackersByName("all")
+	for _, cfg := range packerConfigs(packers) {
+		unpacked, _ := PackBoxesWith(cfg, boxes, 50, 20, 0, 0)
+		if cfg.AllowRotate {
+			if unpacked != 0 || !boxes[0].packRotated || boxes[0].destRect.Size() != image.Pt(40, 10) {
+				t.Errorf("%s: expected a rotated 40x10 box, got %v", cfg.Packers[0].Name(), boxes[0].destRect)
+			}
+		} else if unpacked != 1 {
+			t.Errorf("%s: packed without rotation", cfg.Packers[0].Name())
+		}
+	}
+}
+
+func TestRenderRotation(t *testing.T) {
+	// a 3x2 logical image, and the same stored rotated as an atlas "rotate: 90" region would be
+	logical := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := range logical.Pix {
+		logical.Pix[i] = uint8(i * 10)
+	}
+	stored := imaging.Rotate90(logical)
+	images := []image.Image{logical, stored}
+
+	cases := []struct {
+		box      BoxTranslation
+		expected image.Image
+	}{
+		{BoxTranslation{imgSrc: 0, sourceRect: logical.Bounds(), destRect: image.Rect(0, 0, 3, 2)}, logical},
+		{BoxTranslation{imgSrc: 0, sourceRect: logical.Bounds(), destRect: image.Rect(0, 0, 2, 3), packRotated: true}, stored},
+		{BoxTranslation{imgSrc: 1, sourceRect: logical.Bounds(), destRect: image.Rect(0, 0, 3, 2), orientation: orientation.Orientation{Rotate: 90}}, logical},
+		{BoxTranslation{imgSrc: 1, sourceRect: logical.Bounds(), destRect: image.Rect(0, 0, 2, 3), orientation: orientation.Orientation{Rotate: 90}, packRotated: true}, stored},
+	}
+	for i, c := range cases {
+		c.box.wasPacked = true
+		out := image.NewNRGBA(c.box.destRect)
+		RenderAll(images, []BoxTranslation{c.box}, out)
+		if !bytes.Equal(out.Pix, imaging.Clone(c.expected).Pix) {
+			t.Errorf("case %d rendered incorrectly", i)
+		}
+	}
+
+	// sources stored with every orientation render upright, or rotated if packed so
+	for _, flip := range []bool{false, true} {
+		for _, degrees := range []int{0, 90, 180, 270} {
+			o := orientation.Orientation{Rotate: degrees, Flip: flip}
+			src := o.Apply(logical)
+			for _, packRotated := range []bool{false, true} {
+				box := BoxFromRect(0, logical.Bounds(), o)
+				box.PlaceAt(image.Point{})
+				expected := logical
+				if packRotated {
+					box.packRotated = true
+					box.destRect = image.Rect(0, 0, 2, 3)
+					expected = stored
+				}
+				out := image.NewNRGBA(box.destRect)
+				RenderAll([]image.Image{src}, []BoxTranslation{box}, out)
+				if !bytes.Equal(out.Pix, imaging.Clone(expected).Pix) {
+					t.Errorf("%v, pack rotated %v: rendered incorrectly", o, packRotated)
+				}
+			}
+		}
+	}
+}
+
+func TestFindMaxMargin(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	packs := func(cfg PackConfig, boxes []BoxTranslation, W, H, margin int) bool {
+		work := make([]BoxTranslation, len(boxes))
+		copy(work, boxes)
+		unpacked, _ := PackBoxesWith(cfg, work, W, H, margin, 0)
+		return unpacked == 0
+	}
+	// small, crowded sheets find margins where packing isn't monotonic
+	for trial := 0; trial < 500; trial++ {
+		boxes := make([]BoxTranslation, 1+rng.Intn(60))
+		for i := range boxes {
+			boxes[i].sourceRect = image.Rect(0, 0, 1+rng.Intn(40), 1+rng.Intn(40))
+		}
+		W, H := 50+rng.Intn(300), 50+rng.Intn(300)
+		cfg := DefaultPackConfig()
+		if !packs(cfg, boxes, W, H, 0) {
+			continue
+		}
+		linear := 0
+		for packs(cfg, boxes, W, H, linear+1) {
+			linear++
+		}
+
+		margin, attempts := FindMaxMargin(cfg, boxes, W, H, 0)
+		if margin != linear {
+			t.Errorf("trial %d: margin %d, linear search found %d", trial, margin, linear)
+		}
+		if linear > 8 && attempts >= linear {
+			t.Errorf("trial %d: %d attempts, linear search needed %d", trial, attempts, linear+1)
+		}
+	}
+}
+
+func TestFindMinSize(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	constraints := []SizeConstraints{
+		{},
+		{PowerOfTwo: true},
+		{Multiple: 6},
+		{MaxAspect: 1.5},
+		{PowerOfTwo: true, MaxAspect: 2},
+		{FixedWidth: 30},
+		{FixedWidth: 30, PowerOfTwo: true},
+	}
+	for trial := 0; trial < 8; trial++ {
+		boxes := make([]BoxTranslation, 1+rng.Intn(8))
+		for i := range boxes {
+			boxes[i].sourceRect = image.Rect(0, 0, 1+rng.Intn(12), 1+rng.Intn(12))
+		}
+		cfg := DefaultPackConfig()
+		for ci, c := range constraints {
+			W, H, _ := FindMinSize(cfg, boxes, 1, c)
+
+			// brute force, relying on the default packer never losing a fit as H grows
+			bestW, bestH := 0, 0
+			for w := 1; w <= 128; w++ {
+				if !c.valid(w) || (c.FixedWidth > 0 && w != c.FixedWidth) {
+					continue
+				}
+				for h := 1; h <= 128; h++ {
+					if !c.valid(h) || (c.MaxAspect > 0 && float64(max(w, h)) > c.MaxAspect*float64(min(w, h))) {
+						continue
+					}
+					work := make([]BoxTranslation, len(boxes))
+					copy(work, boxes)
+					if unpacked := PackBoxes(work, w, h, 1, 0); unpacked != 0 {
+						continue
+					}
+					if bestW == 0 || w*h < bestW*bestH || (w*h == bestW*bestH && abs(w-h) < abs(bestW-bestH)) {
+						bestW, bestH = w, h
+					}
+					break
+				}
+			}
+			if W != bestW || H != bestH {
+				t.Errorf("trial %d, constraints %d: found %dx%d, brute force found %dx%d", trial, ci, W, H, bestW, bestH)
+			}
+		}
+	}
+}
+
+func TestRenderMask(t *testing.T) {
+	// a 2x2 source where only the diagonal belongs to the island
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for i := range src.Pix {
+		src.Pix[i] = 255
+	}
+	mask := image.NewAlpha(src.Bounds())
+	mask.SetAlpha(0, 0, color.Alpha{255})
+	mask.SetAlpha(1, 1, color.Alpha{255})
+
+	for _, rotated := range []bool{false, true} {
+		box := BoxFromMask(0, src.Bounds(), mask)
+		box.wasPacked, box.packRotated = true, rotated
+		box.destRect = image.Rect(1, 1, 3, 3)
+		out := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+		RenderAll([]image.Image{src}, []BoxTranslation{box}, out)
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 4; x++ {
+				// rotating the diagonal 90 degrees gives the anti-diagonal
+				expected := x == y && x >= 1 && x <= 2
+				if rotated {
+					expected = x+y == 3 && x >= 1 && x <= 2
+				}
+				if opaque := out.NRGBAAt(x, y).A == 255; opaque != expected {
+					t.Errorf("rotated %v: pixel %d,%d opaque %v, expected %v", rotated, x, y, opaque, expected)
+				}
+			}
+		}
+	}
+}
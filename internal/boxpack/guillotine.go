@@ -0,0 +1,88 @@
+package boxpack
+
+import "image"
+
+/*
+Guillotine packing, after Jukka Jylänki's "A Thousand Ways to Pack the Bin".
+Free space is kept as disjoint rects, each placement cuts its free rect in two with one straight cut.
+Free rects are chosen by best area fit, and cut along the shorter leftover axis.
+*/
+
+type GuillotinePacker struct{}
+
+func (GuillotinePacker) Name() string {
+	return "guillotine"
+}
+
+func (GuillotinePacker) packRects(rects []packRect, W, H int, order SortOrder) {
+	sortRects(rects, order)
+	free := []image.Rectangle{image.Rect(0, 0, W, H)}
+	for i := range rects {
+		r := &rects[i]
+		if r.w == 0 || r.h == 0 {
+			// empty rect needs no space
+			r.x, r.y, r.wasPacked = 0, 0, true
+			continue
+		}
+
+		// best area fit, ties broken by best short side fit
+		best, bestRotated := -1, false
+		bestArea, bestShortSide := 0, 0
+		for j, f := range free {
+			for _, rotated := range []bool{false, true} {
+				w, h := r.w, r.h
+				if rotated {
+					if !r.canRotate || w == h {
+						continue
+					}
+					w, h = h, w
+				}
+				if f.Dx() < w || f.Dy() < h {
+					continue
+				}
+				area := f.Dx()*f.Dy() - w*h
+				shortSide := min(f.Dx()-w, f.Dy()-h)
+				if best < 0 || area < bestArea || (area == bestArea && shortSide < bestShortSide) {
+					best, bestRotated, bestArea, bestShortSide = j, rotated, area, shortSide
+				}
+			}
+		}
+		if best < 0 {
+			r.x, r.y, r.wasPacked = 0, 0, false
+			continue
+		}
+		if bestRotated {
+			r.rotate()
+		}
+
+		f := free[best]
+		free = append(free[:best], free[best+1:]...)
+		placed := image.Rect(f.Min.X, f.Min.Y, f.Min.X+r.w, f.Min.Y+r.h)
+		free = append(free, guillotineSplit(f, placed)...)
+		r.x, r.y, r.wasPacked = placed.Min.X, placed.Min.Y, true
+	}
+}
+
+// cuts the free rect f around placed (which sits in its top left), returning the non-empty remainders.
+// the cut runs along the shorter leftover axis, leaving the larger remainder as big as possible.
+func guillotineSplit(f, placed image.Rectangle) []image.Rectangle {
+	leftoverX := f.Dx() - placed.Dx()
+	leftoverY := f.Dy() - placed.Dy()
+	var right, below image.Rectangle
+	if leftoverX <= leftoverY {
+		// horizontal cut: below spans the full width
+		right = image.Rect(placed.Max.X, f.Min.Y, f.Max.X, placed.Max.Y)
+		below = image.Rect(f.Min.X, placed.Max.Y, f.Max.X, f.Max.Y)
+	} else {
+		// vertical cut: right spans the full height
+		right = image.Rect(placed.Max.X, f.Min.Y, f.Max.X, f.Max.Y)
+		below = image.Rect(f.Min.X, placed.Max.Y, placed.Max.X, f.Max.Y)
+	}
+	remainders := make([]image.Rectangle, 0, 2)
+	for _, r := range []image.Rectangle{right, below} {
+		if !r.Empty() {
+			remainders = append(remainders, r)
+		}
+	}
+	return remainders
+}
@@ -0,0 +1,183 @@
+package boxpack
+
+import "image"
+
+/*
+MaxRects packing, after Jukka Jylänki's "A Thousand Ways to Pack the Bin".
+Tracks every maximal free rectangle, which suits sets of many thin islands better than a skyline.
+*/
+
+// how MaxRectsPacker scores a candidate position. Lower scores are better.
+type MaxRectsHeuristic int
+
+const (
+	MaxRectsBSSF MaxRectsHeuristic = iota // best short side fit
+	MaxRectsBAF                           // best area fit
+	MaxRectsBL                            // bottom left
+	MaxRectsCP                            // contact point
+)
+
+type MaxRectsPacker struct {
+	Heuristic MaxRectsHeuristic
+}
+
+func (p MaxRectsPacker) Name() string {
+	switch p.Heuristic {
+	case MaxRectsBAF:
+		return "maxrects-baf"
+	case MaxRectsBL:
+		return "maxrects-bl"
+	case MaxRectsCP:
+		return "maxrects-cp"
+	default:
+		return "maxrects-bssf"
+	}
+}
+
+func (p MaxRectsPacker) packRects(rects []packRect, W, H int, order SortOrder) {
+	sortRects(rects, order)
+	free := []image.Rectangle{image.Rect(0, 0, W, H)}
+	used := make([]image.Rectangle, 0, len(rects))
+	for i := range rects {
+		r := &rects[i]
+		if r.w == 0 || r.h == 0 {
+			// empty rect needs no space
+			r.x, r.y, r.wasPacked = 0, 0, true
+			continue
+		}
+		placed, rotate, ok := p.findPosition(free, used, r.w, r.h, r.canRotate, W, H)
+		if !ok {
+			r.x, r.y, r.wasPacked = 0, 0, false
+			continue
+		}
+		if rotate {
+			r.rotate()
+		}
+		free = splitFreeRects(free, placed)
+		used = append(used, placed)
+		r.x, r.y, r.wasPacked = placed.Min.X, placed.Min.Y, true
+	}
+}
+
+// finds the best scoring free rect for a w x h box, placing it at the free rect's top left.
+// if canRotate, h x w placements are considered too. returns the placement and whether it's rotated.
+func (p MaxRectsPacker) findPosition(free, used []image.Rectangle, w, h int, canRotate bool, W, H int) (image.Rectangle, bool, bool) {
+	var best image.Rectangle
+	found, bestRotated := false, false
+	bestScore1, bestScore2 := 0, 0
+	for _, f := range free {
+		for _, rotated := range []bool{false, true} {
+			cw, ch := w, h
+			if rotated {
+				if !canRotate || w == h {
+					continue
+				}
+				cw, ch = h, w
+			}
+			if f.Dx() < cw || f.Dy() < ch {
+				continue
+			}
+			candidate := image.Rect(f.Min.X, f.Min.Y, f.Min.X+cw, f.Min.Y+ch)
+			score1, score2 := p.score(f, candidate, used, W, H)
+			if !found || score1 < bestScore1 || (score1 == bestScore1 && score2 < bestScore2) {
+				best, bestRotated, bestScore1, bestScore2 = candidate, rotated, score1, score2
+				found = true
+			}
+		}
+	}
+	return best, bestRotated, found
+}
+
+// scores placing candidate within free rect f. Lower is better.
+func (p MaxRectsPacker) score(f, candidate image.Rectangle, used []image.Rectangle, W, H int) (int, int) {
+	leftoverX := f.Dx() - candidate.Dx()
+	leftoverY := f.Dy() - candidate.Dy()
+	shortSide, longSide := min(leftoverX, leftoverY), max(leftoverX, leftoverY)
+	switch p.Heuristic {
+	case MaxRectsBAF:
+		return f.Dx()*f.Dy() - candidate.Dx()*candidate.Dy(), shortSide
+	case MaxRectsBL:
+		return candidate.Max.Y, candidate.Min.X
+	case MaxRectsCP:
+		return -contactScore(candidate, used, W, H), 0
+	default:
+		return shortSide, longSide
+	}
+}
+
+// the total length of candidate's edges touching the sheet edges or already placed rects.
+func contactScore(candidate image.Rectangle, used []image.Rectangle, W, H int) int {
+	score := 0
+	if candidate.Min.X == 0 || candidate.Max.X == W {
+		score += candidate.Dy()
+	}
+	if candidate.Min.Y == 0 || candidate.Max.Y == H {
+		score += candidate.Dx()
+	}
+	for _, u := range used {
+		if u.Min.X == candidate.Max.X || u.Max.X == candidate.Min.X {
+			score += commonInterval(u.Min.Y, u.Max.Y, candidate.Min.Y, candidate.Max.Y)
+		}
+		if u.Min.Y == candidate.Max.Y || u.Max.Y == candidate.Min.Y {
+			score += commonInterval(u.Min.X, u.Max.X, candidate.Min.X, candidate.Max.X)
+		}
+	}
+	return score
+}
+
+// length of the overlap between the intervals [a1, a2) and [b1, b2)
+func commonInterval(a1, a2, b1, b2 int) int {
+	return max(0, min(a2, b2)-max(a1, b1))
+}
+
+// splits every free rect overlapping placed into the maximal rects around it,
+// then drops any free rect contained within another.
+func splitFreeRects(free []image.Rectangle, placed image.Rectangle) []image.Rectangle {
+	kept := make([]image.Rectangle, 0, len(free))
+	added := make([]image.Rectangle, 0, 4)
+	for _, f := range free {
+		if !f.Overlaps(placed) {
+			kept = append(kept, f)
+			continue
+		}
+		if placed.Min.X > f.Min.X {
+			added = append(added, image.Rect(f.Min.X, f.Min.Y, placed.Min.X, f.Max.Y))
+		}
+		if placed.Max.X < f.Max.X {
+			added = append(added, image.Rect(placed.Max.X, f.Min.Y, f.Max.X, f.Max.Y))
+		}
+		if placed.Min.Y > f.Min.Y {
+			added = append(added, image.Rect(f.Min.X, f.Min.Y, f.Max.X, placed.Min.Y))
+		}
+		if placed.Max.Y < f.Max.Y {
+			added = append(added, image.Rect(f.Min.X, placed.Max.Y, f.Max.X, f.Max.Y))
+		}
+	}
+
+	// kept rects were maximal and don't overlap placed, so they can't be contained within an added rect.
+	// only the added rects need checking.
+	survivors := make([]image.Rectangle, 0, len(added))
+	for i, a := range added {
+		contained := false
+		for _, k := range kept {
+			if a.In(k) {
+				contained = true
+				break
+			}
+		}
+		for j, b := range added {
+			if contained {
+				break
+			}
+			// of two identical rects, keep the first
+			if i != j && a.In(b) && (a != b || j < i) {
+				contained = true
+			}
+		}
+		if !contained {
+			survivors = append(survivors, a)
+		}
+	}
+
+	return append(kept, survivors...)
+}
@@ -0,0 +1,185 @@
+package boxpack
+
+import (
+	"fmt"
+	"image"
+)
+
+// A Packer places rects onto a single W x H sheet.
+type Packer interface {
+	Name() string
+	// packs rects in-place, setting x, y and wasPacked. rects may be reordered, placing them in the given order.
+	packRects(rects []packRect, W, H int, order SortOrder)
+}
+
+// the packer used by PackBoxes and PackAllBoxes
+var DefaultPacker Packer = SkylinePacker{}
+
+// how to pack: which packers and sort orders to try and the options they share
+type PackConfig struct {
+	Packers     []Packer    // each is tried, the best result is kept
+	Sorts       []SortOrder // each is tried with every packer, SortHeight if empty
+	AllowRotate bool        // packers may rotate boxes 90 degrees to improve fit
+}
+
+// a packer and sort order combination, as tried by PackBoxesWith
+type Strategy struct {
+	Packer Packer
+	Sort   SortOrder
+}
+
+func (s Strategy) String() string {
+	return s.Packer.Name() + ", sorted by " + s.Sort.String()
+}
+
+// every packer and sort order combination in the config
+func (cfg PackConfig) Strategies() []Strategy {
+	sorts := cfg.Sorts
+	if len(sorts) == 0 {
+		sorts = []SortOrder{SortHeight}
+	}
+	strategies := make([]Strategy, 0, len(cfg.Packers)*len(sorts))
+	for _, p := range cfg.Packers {
+		for _, s := range sorts {
+			strategies = append(strategies, Strategy{Packer: p, Sort: s})
+		}
+	}
+	return strategies
+}
+
+// a PackConfig using only DefaultPacker
+func DefaultPackConfig() PackConfig {
+	return PackConfig{Packers: []Packer{DefaultPacker}}
+}
+
+// every available packer, in the order "all" tries them
+var allPackers = []Packer{
+	SkylinePacker{},
+	MaxRectsPacker{Heuristic: MaxRectsBSSF},
+	MaxRectsPacker{Heuristic: MaxRectsBAF},
+	MaxRectsPacker{Heuristic: MaxRectsBL},
+	MaxRectsPacker{Heuristic: MaxRectsCP},
+	GuillotinePacker{},
+}
+
+// a rectangle to be packed, mirroring stbrp_rect
+type packRect struct {
+	id        int  // index of the originating box
+	w, h      int  // size including margin
+	canRotate bool // packer may place this as h x w
+	x, y      int  // result position, valid if wasPacked
+	rotated   bool // true if placed as h x w
+	wasPacked bool
+}
+
+// swaps the rect's dimensions, toggling its rotated state
+func (r *packRect) rotate() {
+	r.w, r.h = r.h, r.w
+	r.rotated = !r.rotated
+}
+
+// returns the names of all available packers
+func PackerNames() []string {
+	names := make([]string, 0, len(allPackers))
+	for _, p := range allPackers {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+// finds a packer by name. "all" returns every packer.
+func PackersByName(name string) ([]Packer, error) {
+	if name == "all" {
+		return append([]Packer(nil), allPackers...), nil
+	}
+	for _, p := range allPackers {
+		if p.Name() == name {
+			return []Packer{p}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown packer '%s'", name)
+}
+
+// Packs the boxes parameter in-place with each of cfg's strategies, keeping the result with the fewest
+// unpacked boxes, then the best occupancy.
+// boxMargin - additinal padding to provide each box in total, pixels.
+// offset - ammount to offset each box. useful values are half of margin, =margin, or zero.
+// returns the number of unpacked rects remaining and the strategy chosen
+func PackBoxesWith(cfg PackConfig, boxes []BoxTranslation, W, H, boxMargin, offset int) (int, Strategy) {
+	strategies := cfg.Strategies()
+	if len(strategies) == 1 {
+		return packBoxes(strategies[0], cfg.AllowRotate, boxes, W, H, boxMargin, offset), strategies[0]
+	}
+
+	trial := make([]BoxTranslation, len(boxes))
+	best := make([]BoxTranslation, len(boxes))
+	var bestStrategy Strategy
+	bestUnpacked, bestOccupancy := -1, 0.0
+	for _, s := range strategies {
+		copy(trial, boxes)
+		unpacked := packBoxes(s, cfg.AllowRotate, trial, W, H, boxMargin, offset)
+		occupancy := getOccupancy(trial)
+		if bestUnpacked < 0 || unpacked < bestUnpacked || (unpacked == bestUnpacked && occupancy > bestOccupancy) {
+			bestUnpacked, bestOccupancy, bestStrategy = unpacked, occupancy, s
+			copy(best, trial)
+		}
+	}
+	copy(boxes, best)
+	return max(bestUnpacked, 0), bestStrategy
+}
+
+// packs boxes in-place with a single strategy, returning the number left unpacked
+func packBoxes(strategy Strategy, allowRotate bool, boxes []BoxTranslation, W, H, boxMargin, offset int) int {
+	rects := boxesToRects(boxes, boxMargin, allowRotate)
+	strategy.Packer.packRects(rects, W, H, strategy.Sort)
+
+	var unpacked int
+	for _, r := range rects {
+		box := &boxes[r.id]
+		box.wasPacked = r.wasPacked
+		box.packRotated = r.wasPacked && r.rotated
+		if r.wasPacked {
+			w, h := box.sourceRect.Dx(), box.sourceRect.Dy()
+			if box.packRotated {
+				w, h = h, w
+			}
+			box.destRect.Min.X = r.x + offset
+			box.destRect.Min.Y = r.y + offset
+			box.destRect.Max.X = box.destRect.Min.X + w
+			box.destRect.Max.Y = box.destRect.Min.Y + h
+		} else {
+			unpacked++
+		}
+	}
+	return unpacked
+}
+
+// Converts a slice of Box into packRects via the dimensions of box.sourceRect
+func boxesToRects(boxes []BoxTranslation, margin int, allowRotate bool) []packRect {
+	rects := make([]packRect, len(boxes))
+	for i := range boxes {
+		rects[i].id = i
+		rects[i].w = boxes[i].sourceRect.Dx() + margin
+		rects[i].h = boxes[i].sourceRect.Dy() + margin
+		rects[i].canRotate = allowRotate
+	}
+	return rects
+}
+
+// the area of all packed boxes divided by the area of the rect enclosing them.
+// higher is tighter.
+func getOccupancy(boxes []BoxTranslation) float64 {
+	var used image.Rectangle
+	area := 0
+	for _, b := range boxes {
+		if !b.wasPacked {
+			continue
+		}
+		used = used.Union(b.destRect)
+		area += b.destRect.Dx() * b.destRect.Dy()
+	}
+	if used.Empty() {
+		return 0
+	}
+	return float64(area) / float64(used.Dx()*used.Dy())
+}
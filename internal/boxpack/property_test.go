@@ -0,0 +1,163 @@
+package boxpack
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+
+	"github.com/crimro-se/atlas-repacker/internal/orientation"
+)
+
+// a random set of boxes. each box's imgSrc is its index, so it can be identified once packed.
+func randomBoxes(rng *rand.Rand) []BoxTranslation {
+	boxes := make([]BoxTranslation, rng.Intn(60))
+	maxSide := 1 + rng.Intn(80)
+	for i := range boxes {
+		x, y := rng.Intn(100), rng.Intn(100)
+		// a few empty boxes, and plenty of duplicate sizes
+		boxes[i] = BoxFromRect(i, image.Rect(x, y, x+rng.Intn(maxSide), y+rng.Intn(maxSide)), orientation.Upright)
+	}
+	return boxes
+}
+
+// the packer's view of a packed box: its destination, widened by margin and without the offset
+func marginRect(box BoxTranslation, margin, offset int) image.Rectangle {
+	r := box.destRect.Sub(image.Pt(offset, offset))
+	r.Max = r.Max.Add(image.Pt(margin, margin))
+	return r
+}
+
+// checks the properties every packing must hold: boxes keep their identity and source,
+// packed boxes lie within the sheet with their margin, and no two margins overlap.
+// returns the number of unpacked boxes found.
+func checkPacking(t *testing.T, desc string, packed, original []BoxTranslation, W, H, margin, offset int) int {
+	t.Helper()
+	sheet := image.Rect(0, 0, W, H)
+	unpacked := 0
+	for i, box := range packed {
+		src := original[box.imgSrc]
+		if box.sourceRect != src.sourceRect || box.orientation != src.orientation {
+			t.Errorf("%s: box %d from %v, want %v", desc, box.imgSrc, box.sourceRect, src.sourceRect)
+		}
+		if !box.wasPacked {
+			unpacked++
+			continue
+		}
+		size := box.sourceRect.Size()
+		if box.packRotated {
+			size = image.Pt(size.Y, size.X)
+		}
+		if box.destRect.Size() != size {
+			t.Errorf("%s: box %d resized from %v to %v", desc, box.imgSrc, size, box.destRect.Size())
+		}
+		r := marginRect(box, margin, offset)
+		if !r.Empty() && !r.In(sheet) {
+			t.Errorf("%s: box %d with margin %v lies outside %v", desc, box.imgSrc, r, sheet)
+		}
+		for _, other := range packed[i+1:] {
+			if other.wasPacked && r.Overlaps(marginRect(other, margin, offset)) {
+				t.Errorf("%s: boxes %d and %d overlap at %v and %v", desc, box.imgSrc, other.imgSrc, box.destRect, other.destRect)
+			}
+		}
+	}
+	return unpacked
+}
+
+// a box which can't fit on an empty sheet, with its margin, however it's rotated.
+// empty boxes need no space, so always fit.
+func oversized(box BoxTranslation, W, H, margin int, allowRotate bool) bool {
+	w, h := box.sourceRect.Dx()+margin, box.sourceRect.Dy()+margin
+	if w == 0 || h == 0 {
+		return false
+	}
+	fits := w <= W && h <= H
+	return !fits && !(allowRotate && h <= W && w <= H)
+}
+
+func TestPackBoxesProperties(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	packers, _ := PackersByName("all")
+	configs := append(packerConfigs(packers), DefaultPackConfig())
+	for trial := 0; trial < 200; trial++ {
+		original := randomBoxes(rng)
+		W, H := 1+rng.Intn(300), 1+rng.Intn(300)
+		margin := rng.Intn(5)
+		offset := rng.Intn(margin + 1)
+		cfg := configs[rng.Intn(len(configs))]
+
+		boxes := make([]BoxTranslation, len(original))
+		copy(boxes, original)
+		var unpacked int
+		if trial%2 == 0 {
+			unpacked = PackBoxes(boxes, W, H, margin, offset)
+			cfg = DefaultPackConfig()
+		} else {
+			unpacked, _ = PackBoxesWith(cfg, boxes, W, H, margin, offset)
+		}
+		desc := cfg.Strategies()[0].String()
+		for i, box := range boxes {
+			if box.imgSrc != i {
+				t.Fatalf("%s: box %d moved to %d", desc, box.imgSrc, i)
+			}
+			if box.packRotated && !cfg.AllowRotate {
+				t.Errorf("%s: box %d rotated, but rotation isn't allowed", desc, i)
+			}
+			if oversized(box, W, H, margin, cfg.AllowRotate) && box.wasPacked {
+				t.Errorf("%s: box %d packed, but can't fit", desc, i)
+			}
+		}
+		if found := checkPacking(t, desc, boxes, original, W, H, margin, offset); found != unpacked {
+			t.Errorf("%s: %d boxes unpacked, but %d reported", desc, found, unpacked)
+		}
+	}
+}
+
+func TestPackAllBoxesProperties(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for trial := 0; trial < 100; trial++ {
+		original := randomBoxes(rng)
+		W, H := 1+rng.Intn(200), 1+rng.Intn(200)
+		margin := rng.Intn(5)
+		offset := rng.Intn(margin + 1)
+		before := make([]BoxTranslation, len(original))
+		copy(before, original)
+
+		pages, unpacked := PackAllBoxes(original, W, H, margin, offset)
+		for i := range original {
+			if original[i] != before[i] {
+				t.Fatalf("input box %d modified", i)
+			}
+		}
+
+		// every box that can fit is on exactly one page
+		seen := make(map[int]bool)
+		for p, page := range pages {
+			if len(page) == 0 {
+				t.Errorf("page %d is empty", p)
+			}
+			for _, box := range page {
+				if seen[box.imgSrc] {
+					t.Errorf("box %d is on more than one page", box.imgSrc)
+				}
+				seen[box.imgSrc] = true
+			}
+			if found := checkPacking(t, "page", page, original, W, H, margin, offset); found > 0 {
+				t.Errorf("page %d holds %d unpacked boxes", p, found)
+			}
+		}
+		wantUnpacked := 0
+		for i, box := range original {
+			if oversized(box, W, H, margin, false) {
+				wantUnpacked++
+				if seen[i] {
+					t.Errorf("box %d packed, but can't fit", i)
+				}
+			} else if !seen[i] {
+				t.Errorf("box %d fits a %dx%d sheet, but wasn't packed", i, W, H)
+			}
+		}
+		if unpacked != wantUnpacked {
+			t.Errorf("%d boxes unpacked, want %d", unpacked, wantUnpacked)
+		}
+	}
+}
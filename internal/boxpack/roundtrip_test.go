@@ -0,0 +1,121 @@
+package boxpack
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/crimro-se/atlas-repacker/internal/findislands"
+	"github.com/crimro-se/atlas-repacker/internal/orientation"
+)
+
+// detects the islands in findislands' test images, packs and renders them,
+// then checks pixel for pixel that every island arrived intact.
+// Also repeated with sub-images, whose bounds don't start at the origin, as both source and output.
+func TestDetectPackRenderRoundTrip(t *testing.T) {
+	paths, err := filepath.Glob("../findislands/testdata/*.png")
+	if err != nil || len(paths) == 0 {
+		t.Fatal("no test images found", err)
+	}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		full, err := png.Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, offset := range []bool{false, true} {
+			src, outBounds := full, image.Rect(0, 0, 128, 128)
+			if offset {
+				src = full.(*image.NRGBA).SubImage(full.Bounds().Inset(3))
+				outBounds = image.Rect(50, 40, 178, 168)
+			}
+			testRoundTrip(t, path, src, outBounds)
+		}
+	}
+}
+
+// detects, packs and renders src's islands onto a 128x128 output with the given bounds, then checks the result
+func testRoundTrip(t *testing.T, path string, src image.Image, outBounds image.Rectangle) {
+	t.Helper()
+	for _, masked := range []bool{false, true} {
+		for _, rotate := range []bool{false, true} {
+			var boxes []BoxTranslation
+			if masked {
+				for _, island := range findislands.ImageToIslandMasks(src, false) {
+					boxes = append(boxes, BoxFromMask(0, island.Rectangle, island.Mask))
+				}
+			} else {
+				for _, r := range findislands.ImageToIslands(src, false) {
+					boxes = append(boxes, BoxFromRect(0, r, orientation.Upright))
+				}
+			}
+			cfg := DefaultPackConfig()
+			cfg.AllowRotate = rotate
+			if unpacked, _ := PackBoxesWith(cfg, boxes, 128, 128, 2, 1); unpacked != 0 {
+				t.Fatalf("%s: %d boxes unpacked", path, unpacked)
+			}
+			out := image.NewNRGBA(outBounds)
+			RenderAll([]image.Image{src}, boxes, out)
+			checkRendered(t, path, src, out, boxes)
+		}
+	}
+}
+
+// compares each box's output pixels with its source, undoing any packer rotation.
+// masked boxes must only carry their own pixels, every visible source pixel must be carried,
+// and nothing may be drawn outside a box.
+func checkRendered(t *testing.T, name string, src image.Image, out *image.NRGBA, boxes []BoxTranslation) {
+	t.Helper()
+	drawn := image.NewAlpha(out.Bounds())
+	carried := image.NewAlpha(src.Bounds())
+	for i, b := range boxes {
+		w := b.sourceRect.Dx()
+		for y := b.sourceRect.Min.Y; y < b.sourceRect.Max.Y; y++ {
+			for x := b.sourceRect.Min.X; x < b.sourceRect.Max.X; x++ {
+				lx, ly := x-b.sourceRect.Min.X, y-b.sourceRect.Min.Y
+				dest := b.destRect.Min.Add(image.Pt(lx, ly))
+				if b.packRotated {
+					// stored rotated 90 degrees counter-clockwise
+					dest = b.destRect.Min.Add(image.Pt(ly, w-1-lx))
+				}
+				dest = dest.Add(out.Rect.Min)
+				expected := color.NRGBAModel.Convert(src.At(x, y))
+				if b.mask != nil && b.mask.AlphaAt(x, y).A == 0 {
+					expected = color.NRGBA{}
+				} else {
+					carried.SetAlpha(x, y, color.Alpha{255})
+				}
+				if got := out.NRGBAAt(dest.X, dest.Y); got != expected {
+					t.Errorf("%s: box %d source pixel %d,%d rendered as %v, expected %v", name, i, x, y, got, expected)
+					return
+				}
+				drawn.SetAlpha(dest.X, dest.Y, color.Alpha{255})
+			}
+		}
+	}
+	sb := src.Bounds()
+	for y := sb.Min.Y; y < sb.Max.Y; y++ {
+		for x := sb.Min.X; x < sb.Max.X; x++ {
+			if _, _, _, a := src.At(x, y).RGBA(); a > 0 && carried.AlphaAt(x, y).A == 0 {
+				t.Errorf("%s: source pixel %d,%d wasn't carried by any box", name, x, y)
+				return
+			}
+		}
+	}
+	for y := out.Rect.Min.Y; y < out.Rect.Max.Y; y++ {
+		for x := out.Rect.Min.X; x < out.Rect.Max.X; x++ {
+			if drawn.AlphaAt(x, y).A == 0 && out.NRGBAAt(x, y).A != 0 {
+				t.Errorf("%s: pixel %d,%d drawn outside any box", name, x, y)
+				return
+			}
+		}
+	}
+}
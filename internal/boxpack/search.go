@@ -0,0 +1,246 @@
+package boxpack
+
+import (
+	"math"
+	"sort"
+)
+
+// how many margins below the bisected result FindMaxMargin checks also pack
+const bracketScan = 16
+
+// Finds the largest margin, from start upwards, for which every box still packs. Boxes aren't modified.
+// start is presumed to pack already. The margin step is doubled until packing fails, then bisected.
+// Packing isn't strictly monotonic in margin, so bisection can step over a failing margin. Up to
+// bracketScan margins below the bisected result, within the final probe bracket, are then scanned
+// upwards, and the result is the first of those that fails, less one.
+// returns the margin found and the number of pack attempts made
+func FindMaxMargin(cfg PackConfig, boxes []BoxTranslation, W, H, start int) (int, int) {
+	work := make([]BoxTranslation, len(boxes))
+	attempts := 0
+	packs := func(margin int) bool {
+		attempts++
+		copy(work, boxes)
+		unpacked, _ := PackBoxesWith(cfg, work, W, H, margin, 0)
+		return unpacked == 0
+	}
+
+	// exponential probing for a failing margin. nothing can pack with a margin beyond the sheet.
+	good, bad := start, -1
+	for step := 1; bad < 0; step *= 2 {
+		probe := start + step
+		if probe > max(W, H) || !packs(probe) {
+			bad = probe
+		} else {
+			good = probe
+		}
+	}
+	probed := good
+
+	// bisection between the largest known good and smallest known bad margins
+	for bad-good > 1 {
+		mid := good + (bad-good)/2
+		if packs(mid) {
+			good = mid
+		} else {
+			bad = mid
+		}
+	}
+
+	// margins just below good that bisection stepped over
+	for margin := max(probed+1, good-bracketScan); margin < good; margin++ {
+		if !packs(margin) {
+			return margin - 1, attempts
+		}
+	}
+	return good, attempts
+}
+
+// constraints on the output sizes FindMinSize may choose
+type SizeConstraints struct {
+	PowerOfTwo bool    // W and H must both be powers of two
+	Multiple   int     // if > 0, W and H must both be multiples of this
+	MaxAspect  float64 // if > 0, the longer side may be at most this many times the shorter
+	FixedWidth int     // if > 0, W is fixed and only H is searched
+}
+
+// true if v is an acceptable side length
+func (c SizeConstraints) valid(v int) bool {
+	if v < 1 {
+		return false
+	}
+	if c.PowerOfTwo && v&(v-1) != 0 {
+		return false
+	}
+	return c.Multiple < 1 || v%c.Multiple == 0
+}
+
+// smallest acceptable side length >= v, or -1 if there's none <= limit
+func (c SizeConstraints) nextValid(v, limit int) int {
+	v = max(v, 1)
+	for v <= limit {
+		if c.valid(v) {
+			return v
+		}
+		v++
+	}
+	return -1
+}
+
+// largest acceptable side length <= v, or -1 if there's none >= limit
+func (c SizeConstraints) prevValid(v, limit int) int {
+	limit = max(limit, 1)
+	for v >= limit {
+		if c.valid(v) {
+			return v
+		}
+		v--
+	}
+	return -1
+}
+
+// how many widths FindMinSize tries each one of. Beyond this, widths are sampled
+// coarseWidths at a time, narrowing around the best found.
+const (
+	exhaustiveWidths = 128
+	coarseWidths     = 32
+)
+
+// Finds the output W & H of least area for which every box packs, subject to the constraints.
+// Equal areas prefer the squarer size, then the narrower. Boxes aren't modified.
+// For each width tried, the smallest height is bisected, which assumes that anything packing at one height
+// also packs when taller. This holds for the skyline bottom left heuristic, other packers may find a larger
+// size than the least. Every width is tried when there are few, otherwise they're sampled coarsely, then
+// ever more finely around the best found, which may also miss the least area by a little.
+// returns W, H and the number of pack attempts made. W & H are 0 if nothing satisfies the constraints.
+func FindMinSize(cfg PackConfig, boxes []BoxTranslation, margin int, c SizeConstraints) (int, int, int) {
+	work := make([]BoxTranslation, len(boxes))
+	attempts := 0
+	packs := func(W, H int) bool {
+		attempts++
+		copy(work, boxes)
+		unpacked, _ := PackBoxesWith(cfg, work, W, H, margin, 0)
+		return unpacked == 0
+	}
+
+	// bounds: no side can be smaller than the largest box, no side needs to be larger than all boxes in a row.
+	minW, minH, limit := 1, 1, 1
+	for _, b := range boxes {
+		w, h := b.sourceRect.Dx()+margin, b.sourceRect.Dy()+margin
+		if cfg.AllowRotate {
+			minW = max(minW, min(w, h))
+			minH = max(minH, min(w, h))
+		} else {
+			minW = max(minW, w)
+			minH = max(minH, h)
+		}
+		limit += max(w, h)
+	}
+	// constraints may push the smallest acceptable row length beyond it
+	limit = max(limit*2, c.FixedWidth) + c.Multiple
+	area := getSourceArea(boxes, margin)
+
+	bestW, bestH, bestArea := 0, 0, 0
+	tried := make(map[int]bool)
+	// finds the smallest height for width W, keeping it if the area's the least so far
+	tryWidth := func(W int) {
+		if tried[W] {
+			return
+		}
+		tried[W] = true
+		hLow := max(minH, (area+W-1)/W)
+		hHigh := limit
+		if c.MaxAspect > 0 {
+			hLow = max(hLow, int(math.Ceil(float64(W)/c.MaxAspect)))
+			hHigh = min(hHigh, int(math.Floor(float64(W)*c.MaxAspect)))
+		}
+		if bestArea > 0 {
+			hHigh = min(hHigh, bestArea/W)
+		}
+		lo := c.nextValid(hLow, hHigh)
+		hi := c.prevValid(hHigh, hLow)
+		if lo < 0 || hi < 0 || !packs(W, hi) {
+			return
+		}
+
+		// bisect for the smallest acceptable height that packs
+		good, bad := hi, 0
+		if lo == hi || packs(W, lo) {
+			good = lo
+		} else {
+			bad = lo
+		}
+		for bad > 0 {
+			m := bad + (good-bad+1)/2
+			mid := c.nextValid(m, good-1)
+			if mid < 0 {
+				mid = c.prevValid(m-1, bad+1)
+			}
+			if mid < 0 {
+				break
+			}
+			if packs(W, mid) {
+				good = mid
+			} else {
+				bad = mid
+			}
+		}
+
+		// equal areas prefer the squarer, then the narrower, whichever order widths are tried in
+		H := good
+		squarer := abs(W-H) < abs(bestW-bestH) || (abs(W-H) == abs(bestW-bestH) && W < bestW)
+		if bestArea == 0 || W*H < bestArea || (W*H == bestArea && squarer) {
+			bestW, bestH, bestArea = W, H, W*H
+		}
+	}
+
+	if c.FixedWidth > 0 {
+		if c.valid(c.FixedWidth) {
+			tryWidth(c.FixedWidth)
+		}
+		return bestW, bestH, attempts
+	}
+
+	var widths []int
+	for W := c.nextValid(minW, limit); W > 0; W = c.nextValid(W+1, limit) {
+		widths = append(widths, W)
+	}
+	if len(widths) == 0 {
+		return 0, 0, attempts
+	}
+	// a squarish width first, whose area rules out the widest
+	tryWidth(widths[min(sort.SearchInts(widths, int(math.Sqrt(float64(area)))), len(widths)-1)])
+
+	lo, hi := 0, len(widths)-1
+	exhaustive := false
+	for {
+		// widths only grow from here, nothing further can do better
+		for bestArea > 0 && hi > lo && widths[hi]*minH > bestArea {
+			hi--
+		}
+		step := 1
+		if !exhaustive && hi-lo >= exhaustiveWidths {
+			step = (hi-lo)/coarseWidths + 1
+		}
+		for i := lo; i <= hi; i += step {
+			tryWidth(widths[i])
+		}
+		if step == 1 {
+			break
+		}
+		if bestW == 0 {
+			// so little packs that sampling found none of it
+			exhaustive = true
+			continue
+		}
+		best := sort.SearchInts(widths, bestW)
+		lo, hi = max(lo, best-step), min(hi, best+step)
+	}
+	return bestW, bestH, attempts
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,224 @@
+package boxpack
+
+import "fmt"
+
+/*
+A native Go port of the skyline packer in stb_rect_pack.h.
+Placements match stb's for the same heuristic and rect order. Sorting is done in Go for both (see stb.go).
+Unlike stb we never run out of nodes, so widths are never quantized (stb's align is 1 for us anyway,
+as PackBoxes always gave it at least W nodes).
+*/
+
+// which skyline heuristic to use, mirroring STBRP_HEURISTIC_Skyline_*
+type SkylineHeuristic int
+
+const (
+	SkylineBL SkylineHeuristic = iota // bottom left, stb's default
+	SkylineBF                         // best fit
+)
+
+var skylineHeuristicNames = []string{"bl", "bf"}
+
+func (h SkylineHeuristic) String() string {
+	return skylineHeuristicNames[h]
+}
+
+// finds a skyline heuristic by name. "all" returns every heuristic.
+func SkylineHeuristicsByName(name string) ([]SkylineHeuristic, error) {
+	if name == "all" {
+		return []SkylineHeuristic{SkylineBL, SkylineBF}, nil
+	}
+	for i, n := range skylineHeuristicNames {
+		if n == name {
+			return []SkylineHeuristic{SkylineHeuristic(i)}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown skyline heuristic '%s'", name)
+}
+
+// packs using a skyline, as stb_rect_pack.h does.
+// stb can't rotate, so when rotation is allowed rects are laid flat (wider than tall) beforehand,
+// which keeps the skyline low.
+type SkylinePacker struct {
+	Heuristic SkylineHeuristic
+}
+
+func (p SkylinePacker) Name() string {
+	if p.Heuristic == SkylineBF {
+		return "skyline-bf"
+	}
+	return "skyline"
+}
+
+func (p SkylinePacker) packRects(rects []packRect, W, H int, order SortOrder) {
+	for i := range rects {
+		if rects[i].canRotate && rects[i].h > rects[i].w {
+			rects[i].rotate()
+		}
+	}
+	sortRects(rects, order)
+	skylineBackend(rects, W, H, p.Heuristic)
+}
+
+type skylineNode struct {
+	x, y int
+	next *skylineNode
+}
+
+type skylineContext struct {
+	width, height int
+	heuristic     SkylineHeuristic
+	activeHead    *skylineNode
+}
+
+func newSkylineContext(W, H int, heuristic SkylineHeuristic) *skylineContext {
+	// the first node is the full width, the second is a sentinel (lets us not store width explicitly)
+	sentinel := &skylineNode{x: W, y: 1 << 30}
+	return &skylineContext{width: W, height: H, heuristic: heuristic, activeHead: &skylineNode{next: sentinel}}
+}
+
+// packs rects in-place and in slice order, setting x, y and wasPacked.
+func skylinePackRects(rects []packRect, W, H int, heuristic SkylineHeuristic) {
+	ctx := newSkylineContext(W, H, heuristic)
+	for i := range rects {
+		r := &rects[i]
+		if r.w == 0 || r.h == 0 {
+			// empty rect needs no space
+			r.x, r.y, r.wasPacked = 0, 0, true
+			continue
+		}
+		r.x, r.y, r.wasPacked = ctx.packRectangle(r.w, r.h)
+	}
+}
+
+// find minimum y position if it starts at x0, along with the area wasted beneath it.
+func (c *skylineContext) findMinY(first *skylineNode, x0, width int) (int, int) {
+	node := first
+	x1 := x0 + width
+	minY, visitedWidth, waste := 0, 0, 0
+	for node.x < x1 {
+		if node.y > minY {
+			// raise minY higher.
+			// we've accounted for all waste up to minY,
+			// but we'll now add more waste for everything we've visited
+			waste += visitedWidth * (node.y - minY)
+			minY = node.y
+			// the first time through, visitedWidth might be reduced
+			if node.x < x0 {
+				visitedWidth += node.next.x - x0
+			} else {
+				visitedWidth += node.next.x - node.x
+			}
+		} else {
+			underWidth := node.next.x - node.x
+			if underWidth+visitedWidth > width {
+				underWidth = width - visitedWidth
+			}
+			waste += underWidth * (minY - node.y)
+			visitedWidth += underWidth
+		}
+		node = node.next
+	}
+	return minY, waste
+}
+
+// returns the best position for a rect and the link pointing at the node it starts on.
+// the link is nil if it can't be placed.
+func (c *skylineContext) findBestPos(width, height int) (int, int, **skylineNode) {
+	bestWaste, bestX, bestY := 1<<30, 0, 1<<30
+	var best **skylineNode
+
+	// if it can't possibly fit, bail immediately
+	if width > c.width || height > c.height {
+		return 0, 0, nil
+	}
+
+	node := c.activeHead
+	prev := &c.activeHead
+	for node.x+width <= c.width {
+		y, waste := c.findMinY(node, node.x, width)
+		if c.heuristic == SkylineBL {
+			if y < bestY {
+				bestY = y
+				best = prev
+			}
+		} else if y+height <= c.height {
+			// best fit can only use it if it fits vertically
+			if y < bestY || (y == bestY && waste < bestWaste) {
+				bestY = y
+				bestWaste = waste
+				best = prev
+			}
+		}
+		prev = &node.next
+		node = node.next
+	}
+
+	if best != nil {
+		bestX = (*best).x
+	}
+
+	// best fit also tries aligning the right edge to each node position,
+	// which can reduce waste where bottom left always chooses left-aligned.
+	if c.heuristic == SkylineBF {
+		tail := c.activeHead
+		node = c.activeHead
+		prev = &c.activeHead
+		// find first node that's admissible
+		for tail.x < width {
+			tail = tail.next
+		}
+		for ; tail != nil; tail = tail.next {
+			xpos := tail.x - width
+			// find the left position that matches this
+			for node.next.x <= xpos {
+				prev = &node.next
+				node = node.next
+			}
+			y, waste := c.findMinY(node, xpos, width)
+			if y+height <= c.height && y <= bestY {
+				if y < bestY || waste < bestWaste || (waste == bestWaste && xpos < bestX) {
+					bestX = xpos
+					bestY = y
+					bestWaste = waste
+					best = prev
+				}
+			}
+		}
+	}
+	return bestX, bestY, best
+}
+
+// places a single rect, updating the skyline. returns its position and true on success.
+func (c *skylineContext) packRectangle(width, height int) (int, int, bool) {
+	x, y, prevLink := c.findBestPos(width, height)
+	if prevLink == nil || y+height > c.height {
+		return 0, 0, false
+	}
+
+	node := &skylineNode{x: x, y: y + height}
+
+	// insert the new node into the right starting point, and
+	// let cur point to the remaining nodes needing to be stitched back in
+	cur := *prevLink
+	if cur.x < x {
+		// preserve the existing one, so start testing with the next one
+		next := cur.next
+		cur.next = node
+		cur = next
+	} else {
+		*prevLink = node
+	}
+
+	// drop nodes now covered by the new one
+	for cur.next != nil && cur.next.x <= x+width {
+		cur = cur.next
+	}
+
+	// stitch the list back in
+	node.next = cur
+	if cur.x < x+width {
+		cur.x = x + width
+	}
+	return x, y, true
+}
@@ -0,0 +1,78 @@
+package boxpack
+
+import (
+	"fmt"
+	"sort"
+)
+
+// the order in which packers place rects
+type SortOrder int
+
+const (
+	SortHeight    SortOrder = iota // tallest first, then widest. stb's order
+	SortWidth                      // widest first, then tallest
+	SortArea                       // largest area first
+	SortPerimeter                  // largest perimeter first
+	SortMaxSide                    // longest side first
+	SortNone                       // input order
+)
+
+var sortOrderNames = []string{"height", "width", "area", "perimeter", "maxside", "none"}
+
+func (s SortOrder) String() string {
+	return sortOrderNames[s]
+}
+
+// finds a sort order by name. "all" returns every sort order.
+func SortOrdersByName(name string) ([]SortOrder, error) {
+	if name == "all" {
+		orders := make([]SortOrder, len(sortOrderNames))
+		for i := range orders {
+			orders[i] = SortOrder(i)
+		}
+		return orders, nil
+	}
+	for i, n := range sortOrderNames {
+		if n == name {
+			return []SortOrder{SortOrder(i)}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown sort order '%s'", name)
+}
+
+// the primary sort key of a rect for the given order, larger sorts first
+func (s SortOrder) key(r packRect) int {
+	switch s {
+	case SortWidth:
+		return r.w
+	case SortArea:
+		return r.w * r.h
+	case SortPerimeter:
+		return r.w + r.h
+	case SortMaxSide:
+		return max(r.w, r.h)
+	default:
+		return r.h
+	}
+}
+
+// sorts rects in-place, largest first by the given order.
+// ties are broken by height then width, then input order.
+func sortRects(rects []packRect, order SortOrder) {
+	if order == SortNone {
+		return
+	}
+	sort.SliceStable(rects, func(i, j int) bool {
+		p, q := rects[i], rects[j]
+		if kp, kq := order.key(p), order.key(q); kp != kq {
+			return kp > kq
+		}
+		if order == SortWidth {
+			return p.h > q.h
+		}
+		if p.h != q.h {
+			return p.h > q.h
+		}
+		return p.w > q.w
+	})
+}
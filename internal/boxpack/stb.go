@@ -0,0 +1,163 @@
+//go:build stb && cgo
+
+// makes stb_rect_pack.h usable in Go.
+package boxpack
+
+/*
+	#include <stdlib.h>
+	#include <stdio.h>
+	#include <string.h>
+
+static int rect_height_compare(const void *a, const void *b);
+
+// qsort isn't stable, so rects of identical size could swap places depending on the libc.
+// A stable merge sort makes stb's results deterministic and comparable with skyline.go
+static void stableSortRecurse(char *base, char *tmp, size_t n, size_t size, int (*cmp)(const void *, const void *)) {
+	size_t mid, i, j, k;
+	if (n < 2) {
+		return;
+	}
+	mid = n / 2;
+	stableSortRecurse(base, tmp, mid, size, cmp);
+	stableSortRecurse(base + mid*size, tmp, n-mid, size, cmp);
+	i = 0;
+	j = mid;
+	k = 0;
+	while (i < mid && j < n) {
+		if (cmp(base + j*size, base + i*size) < 0) {
+			memcpy(tmp + k*size, base + j*size, size);
+			j++;
+		} else {
+			memcpy(tmp + k*size, base + i*size, size);
+			i++;
+		}
+		k++;
+	}
+	memcpy(tmp + k*size, base + i*size, (mid-i)*size);
+	k += mid-i;
+	memcpy(tmp + k*size, base + j*size, (n-j)*size);
+	memcpy(base, tmp, n*size);
+}
+
+static void stableSort(void *base, int n, size_t size, int (*cmp)(const void *, const void *)) {
+	char *tmp;
+	// rects arrive already sorted as Go wants them, so skip stb's own height sort
+	if (n < 2 || cmp == rect_height_compare) {
+		return;
+	}
+	tmp = (char*)malloc((size_t)n * size);
+	if (tmp == NULL) {
+		fprintf(stderr, "Memory allocation for sorting failed\n");
+		exit(EXIT_FAILURE);
+	}
+	stableSortRecurse((char*)base, tmp, n, size, cmp);
+	free(tmp);
+}
+
+	#define STBRP_SORT stableSort
+	#define STB_RECT_PACK_IMPLEMENTATION
+
+	#include "stb_rect_pack.h"
+
+struct stbrp_rect* allocateRects(int n) {
+   struct stbrp_rect* array;
+
+   // Allocate memory for the array of n structs
+   array = (struct stbrp_rect*)calloc(n, sizeof(struct stbrp_rect));
+
+   if (array == NULL) {
+      fprintf(stderr, "Memory allocation for rects failed\n");
+      exit(EXIT_FAILURE);
+   }
+
+   return array;
+}
+
+struct stbrp_node* allocateNodes(int n) {
+   struct stbrp_node* array;
+
+   // Allocate memory for the array of n structs
+   array = (struct stbrp_node*)calloc(n, sizeof(struct stbrp_node));
+
+   if (array == NULL) {
+      fprintf(stderr, "Memory allocation for nodes failed\n");
+      exit(EXIT_FAILURE);
+   }
+
+   return array;
+}
+
+struct stbrp_context* allocateCTX() {
+   struct stbrp_context* array;
+
+   // Allocate memory for the array of n structs
+   array = (struct stbrp_context*)calloc(1, sizeof(struct stbrp_context));
+
+   if (array == NULL) {
+      fprintf(stderr, "Memory allocation for ctx failed\n");
+      exit(EXIT_FAILURE);
+   }
+
+   return array;
+}
+
+// no bounds checking btw
+void assignValue(struct stbrp_rect* array, int index, struct stbrp_rect* value) {
+   array[index] = *value;
+}
+
+void getValue(struct stbrp_rect* array, int index, struct stbrp_rect* value) {
+   *value= array[index];
+}
+
+void myFree(void *mem){
+	free(mem);
+}
+*/
+import "C"
+import "unsafe"
+
+// packs rects in slice order, on the skyline implementation chosen by build tags
+func skylineBackend(rects []packRect, W, H int, heuristic SkylineHeuristic) {
+	stbPackRects(rects, W, H, heuristic)
+}
+
+// packs rects in-place via stb_rect_pack.h, in slice order
+func stbPackRects(rects []packRect, W, H int, heuristic SkylineHeuristic) {
+	if len(rects) == 0 {
+		return
+	}
+	stbr := C.allocateRects(C.int(len(rects)))
+	defer C.myFree(unsafe.Pointer(stbr))
+	rectsToSTBR(rects, stbr)
+	ctx := C.allocateCTX()
+	defer C.myFree(unsafe.Pointer(ctx))
+	nodeCount := max(512, W, len(rects))
+	nodes := C.allocateNodes(C.int(nodeCount))
+	defer C.myFree(unsafe.Pointer(nodes))
+	C.stbrp_init_target(ctx, C.int(W), C.int(H), nodes, C.int(nodeCount))
+	if heuristic == SkylineBF {
+		C.stbrp_setup_heuristic(ctx, C.STBRP_HEURISTIC_Skyline_BF_sortHeight)
+	}
+	C.stbrp_pack_rects(ctx, stbr, C.int(len(rects)))
+
+	var r C.stbrp_rect
+	for i := range rects {
+		C.getValue(stbr, C.int(i), &r)
+		rects[i].wasPacked = r.was_packed > 0
+		rects[i].x = int(r.x)
+		rects[i].y = int(r.y)
+	}
+}
+
+// Converts a slice of packRect into a C array of stbrp_rect
+// stbr pointer is presumed to point to an array of sufficient size.
+func rectsToSTBR(rects []packRect, stbr *C.stbrp_rect) {
+	var r C.stbrp_rect
+	for i := range rects {
+		r.id = C.int(rects[i].id)
+		r.w = C.int(rects[i].w)
+		r.h = C.int(rects[i].h)
+		C.assignValue(stbr, C.int(i), &r)
+	}
+}
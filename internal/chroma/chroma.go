@@ -0,0 +1,119 @@
+// package for keying out a background colour, for inputs such as JPEGs which lack an alpha channel.
+package chroma
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// which colour to treat as transparent
+type Key struct {
+	Color     color.NRGBA // ignored if Auto
+	Tolerance int         // maximum difference of any one channel from Color still considered a match
+	Auto      bool        // detect Color per image from its corners
+}
+
+// parses "#RRGGBB[,tolerance]" or "auto[,tolerance]"
+func ParseKey(s string) (Key, error) {
+	var key Key
+	value, tolerance, hasTolerance := strings.Cut(strings.TrimSpace(s), ",")
+	if hasTolerance {
+		t, err := strconv.Atoi(strings.TrimSpace(tolerance))
+		if err != nil || t < 0 || t > 255 {
+			return key, fmt.Errorf("invalid chroma tolerance '%s', should be 0 to 255", tolerance)
+		}
+		key.Tolerance = t
+	}
+
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "auto" {
+		key.Auto = true
+		return key, nil
+	}
+	hex, ok := strings.CutPrefix(value, "#")
+	if !ok || len(hex) != 6 {
+		return key, fmt.Errorf("invalid chroma colour '%s', should be #RRGGBB or auto", value)
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return key, fmt.Errorf("invalid chroma colour '%s', should be #RRGGBB or auto", value)
+	}
+	key.Color = color.NRGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 255}
+	return key, nil
+}
+
+// resolves the key colour for img, detecting it from the corners if Auto
+func (k Key) ColorFor(img image.Image) (color.NRGBA, error) {
+	if !k.Auto {
+		return k.Color, nil
+	}
+	return DetectKey(img, k.Tolerance)
+}
+
+// finds the background colour as the one shared by the most of img's four corners.
+// At least two corners must match (within tolerance), otherwise there's no clear background.
+func DetectKey(img image.Image, tolerance int) (color.NRGBA, error) {
+	b := img.Bounds()
+	if b.Empty() {
+		return color.NRGBA{}, errors.New("can't detect a chroma key for an empty image")
+	}
+	corners := []color.NRGBA{
+		nrgbaAt(img, b.Min.X, b.Min.Y),
+		nrgbaAt(img, b.Max.X-1, b.Min.Y),
+		nrgbaAt(img, b.Min.X, b.Max.Y-1),
+		nrgbaAt(img, b.Max.X-1, b.Max.Y-1),
+	}
+	best, bestVotes := corners[0], 0
+	for _, c := range corners {
+		votes := 0
+		for _, other := range corners {
+			if matches(other, c, tolerance) {
+				votes++
+			}
+		}
+		// ties go to the earliest corner, starting top left
+		if votes > bestVotes {
+			best, bestVotes = c, votes
+		}
+	}
+	if bestVotes < 2 {
+		return best, errors.New("couldn't detect a chroma key, no two corners of the image share a colour")
+	}
+	return best, nil
+}
+
+// returns a copy of img in which every pixel matching key within tolerance has alpha 0.
+func Apply(img image.Image, key color.NRGBA, tolerance int) *image.NRGBA {
+	b := img.Bounds()
+	out := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := nrgbaAt(img, x, y)
+			if matches(c, key, tolerance) {
+				c = color.NRGBA{}
+			}
+			out.SetNRGBA(x, y, c)
+		}
+	}
+	return out
+}
+
+func nrgbaAt(img image.Image, x, y int) color.NRGBA {
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+}
+
+// true if no colour channel of c differs from key by more than tolerance. Alpha is ignored.
+func matches(c, key color.NRGBA, tolerance int) bool {
+	return absDiff(c.R, key.R) <= tolerance && absDiff(c.G, key.G) <= tolerance && absDiff(c.B, key.B) <= tolerance
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
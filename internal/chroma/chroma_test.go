@@ -0,0 +1,76 @@
+package chroma
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected Key
+		valid    bool
+	}{
+		{"#FF00ff", Key{Color: color.NRGBA{255, 0, 255, 255}}, true},
+		{"#00ff00,12", Key{Color: color.NRGBA{0, 255, 0, 255}, Tolerance: 12}, true},
+		{"auto", Key{Auto: true}, true},
+		{"Auto, 5", Key{Auto: true, Tolerance: 5}, true},
+		{"ff00ff", Key{}, false},
+		{"#ff00f", Key{}, false},
+		{"#gg0000", Key{}, false},
+		{"#ff00ff,300", Key{}, false},
+		{"auto,x", Key{}, false},
+	}
+	for _, c := range cases {
+		key, err := ParseKey(c.in)
+		if (err == nil) != c.valid {
+			t.Errorf("%q: expected valid %v, got error %v", c.in, c.valid, err)
+		} else if c.valid && key != c.expected {
+			t.Errorf("%q: parsed as %+v, expected %+v", c.in, key, c.expected)
+		}
+	}
+}
+
+func TestApplyAndDetect(t *testing.T) {
+	// a magenta background with slight noise, a sprite in one corner
+	bg := color.NRGBA{255, 0, 255, 255}
+	img := image.NewRGBA(image.Rect(10, 10, 20, 20))
+	for y := 10; y < 20; y++ {
+		for x := 10; x < 20; x++ {
+			img.Set(x, y, color.NRGBA{255 - uint8(x%3), 0, 255, 255})
+		}
+	}
+	sprite := color.NRGBA{10, 200, 30, 255}
+	img.Set(10, 10, sprite)
+	img.Set(15, 15, sprite)
+
+	key, err := DetectKey(img, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !matches(key, bg, 2) {
+		t.Fatalf("detected %v, expected about %v", key, bg)
+	}
+
+	out := Apply(img, key, 2)
+	if out.Bounds() != img.Bounds() {
+		t.Fatalf("bounds changed from %v to %v", img.Bounds(), out.Bounds())
+	}
+	for y := 10; y < 20; y++ {
+		for x := 10; x < 20; x++ {
+			isSprite := (x == 10 && y == 10) || (x == 15 && y == 15)
+			if c := out.NRGBAAt(x, y); isSprite != (c.A == 255) || (isSprite && c != sprite) {
+				t.Errorf("pixel %d,%d is %v", x, y, c)
+			}
+		}
+	}
+
+	// no two corners agree
+	img.Set(19, 10, color.NRGBA{1, 2, 3, 255})
+	img.Set(10, 19, color.NRGBA{100, 100, 100, 255})
+	img.Set(19, 19, color.NRGBA{0, 0, 0, 255})
+	if _, err := DetectKey(img, 2); err == nil {
+		t.Error("expected detection to fail")
+	}
+}
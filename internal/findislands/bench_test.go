@@ -0,0 +1,88 @@
+package findislands
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+// hides an image's concrete type, forcing the generic img.At path
+type opaqueImage struct {
+	image.Image
+}
+
+// a size x size sprite sheet of random blocks in the given type, built from an NRGBA original
+func benchImage(size int, kind string) image.Image {
+	rng := rand.New(rand.NewSource(1))
+	src := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for n := 0; n < size*size/2000; n++ {
+		x, y := rng.Intn(size), rng.Intn(size)
+		w, h := 4+rng.Intn(60), 4+rng.Intn(60)
+		c := color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+		for py := y; py < min(y+h, size); py++ {
+			for px := x; px < min(x+w, size); px++ {
+				i := src.PixOffset(px, py)
+				src.Pix[i], src.Pix[i+1], src.Pix[i+2], src.Pix[i+3] = c.R, c.G, c.B, c.A
+			}
+		}
+	}
+	switch kind {
+	case "rgba":
+		dst := image.NewRGBA(src.Rect)
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				dst.Set(x, y, src.At(x, y))
+			}
+		}
+		return dst
+	case "paletted":
+		// index 0 is transparent
+		dst := image.NewPaletted(src.Rect, append(color.Palette{color.Transparent}, palette.WebSafe...))
+		for i := 0; i < len(src.Pix); i += 4 {
+			if src.Pix[i+3] > 0 {
+				dst.Pix[i/4] = uint8(1 + int(src.Pix[i])%len(palette.WebSafe))
+			}
+		}
+		return dst
+	case "gray":
+		// black is the background
+		dst := image.NewGray(src.Rect)
+		for i := 0; i < len(src.Pix); i += 4 {
+			dst.Pix[i/4] = src.Pix[i+3]
+		}
+		return dst
+	}
+	return src
+}
+
+func BenchmarkDetect8k(b *testing.B) {
+	for _, kind := range []string{"nrgba", "rgba", "paletted", "gray"} {
+		img := benchImage(8192, kind)
+		d := Detector{}
+		if kind == "gray" {
+			d.LumaThreshold = 1
+		}
+		b.Run(kind+"/fast", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				d.Islands(img)
+			}
+		})
+		b.Run(kind+"/generic", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				d.Islands(opaqueImage{img})
+			}
+		})
+		if kind == "nrgba" {
+			parallel := d
+			parallel.Workers = runtime.GOMAXPROCS(0)
+			b.Run(kind+"/parallel", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					parallel.Islands(img)
+				}
+			})
+		}
+	}
+}
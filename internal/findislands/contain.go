@@ -0,0 +1,104 @@
+package findislands
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"sort"
+)
+
+// what to do with an island lying entirely within another's bounding rect.
+// Unless masked, the container's box copies the nested island's pixels too, duplicating them.
+type Containment int
+
+const (
+	ContainKeep   Containment = iota // nested islands remain separate, only sensible with masks
+	ContainWarn                      // as ContainKeep, also recording each nested island in its container's Nested
+	ContainAbsorb                    // nested islands are merged into their container and recorded in its Nested
+)
+
+var containmentNames = []string{"keep", "warn", "absorb"}
+
+func (c Containment) String() string {
+	return containmentNames[c]
+}
+
+// finds a containment policy by name
+func ContainmentByName(name string) (Containment, error) {
+	for i, n := range containmentNames {
+		if n == name {
+			return Containment(i), nil
+		}
+	}
+	return ContainKeep, fmt.Errorf("unknown containment policy '%s'", name)
+}
+
+// applies policy to every island lying within another's bounding rect.
+// Each nested island is assigned to its largest container, which holds any intermediate ones too.
+// Of islands with identical rects, the first is the container.
+func ResolveContainment(islands []Island, policy Containment) []Island {
+	if policy == ContainKeep || len(islands) < 2 {
+		return islands
+	}
+
+	// largest first, so a nested island's first container found is its largest
+	order := make([]int, len(islands))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return area(islands[order[a]].Rectangle) > area(islands[order[b]].Rectangle)
+	})
+	container := make([]int, len(islands))
+	for i := range container {
+		container[i] = -1
+	}
+	for a, i := range order {
+		for _, j := range order[:a] {
+			if container[j] < 0 && islands[i].In(islands[j].Rectangle) {
+				container[i] = j
+				break
+			}
+		}
+	}
+
+	result := make([]Island, 0, len(islands))
+	index := make([]int, len(islands)) // where each container ended up in result
+	cloned := make([]bool, len(islands))
+	for i, island := range islands {
+		if container[i] >= 0 && policy == ContainAbsorb {
+			continue
+		}
+		index[i] = len(result)
+		result = append(result, island)
+	}
+	for i, c := range container {
+		if c < 0 {
+			continue
+		}
+		parent := &result[index[c]]
+		parent.Nested = append(parent.Nested, islands[i].Rectangle)
+		if policy == ContainAbsorb {
+			parent.Pixels += islands[i].Pixels
+			if parent.Mask != nil && islands[i].Mask != nil {
+				if !cloned[c] {
+					// don't modify the mask shared with the caller's island
+					parent.Mask = cloneAlpha(parent.Mask)
+					cloned[c] = true
+				}
+				draw.Draw(parent.Mask, islands[i].Rectangle, islands[i].Mask, islands[i].Min, draw.Over)
+			}
+		}
+	}
+	return result
+}
+
+func area(r image.Rectangle) int {
+	return r.Dx() * r.Dy()
+}
+
+func cloneAlpha(a *image.Alpha) *image.Alpha {
+	c := image.NewAlpha(a.Rect)
+	copy(c.Pix, a.Pix)
+	return c
+}
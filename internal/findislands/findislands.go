@@ -1,117 +1,184 @@
 package findislands
 
 import (
+	"fmt"
 	"image"
-
-	"golang.org/x/exp/constraints"
+	"runtime"
 )
 
+// a pixel island's bounding rect, along with a mask of which pixels within it belong to the island.
+type Island struct {
+	image.Rectangle
+	Mask   *image.Alpha      // same bounds as Rectangle, opaque where a pixel is part of the island. nil unless requested
+	Pixels int               // number of pixels in the island
+	Nested []image.Rectangle // islands found within this one's bounding rect, see Containment
+}
+
+// how to detect islands. The zero value treats any pixel with alpha > 0 as visible,
+// connecting only horizontally and vertically adjacent pixels, and keeps every island.
+type Detector struct {
+	Diagonal bool // diagonally adjacent pixels are connected too
+
+	// which pixels belong to islands. A pixel must pass every test.
+	// The thresholds are checked directly on the pixel data of common image types,
+	// whereas Visible needs each pixel as a color.Color, which is much slower.
+	AlphaThreshold uint8     // alpha above this, see AlphaAbove
+	LumaThreshold  uint8     // luminance at least this, see LumaAtLeast
+	Visible        Predicate // optional, a custom test
+
+	Merge   int         // islands within this many pixels of each other are merged, see MergeIslands. 0 disables
+	Contain Containment // what to do with islands nested within another's bounding rect
+	Workers int         // goroutines labelling bands of the image in parallel, <= 1 labels on the calling goroutine
+
+	// size filters, islands failing any are rejected. zero values disable them.
+	MinPixels           int     // fewer pixels than this, eg. compression specks
+	MinWidth, MinHeight int     // bounding rect narrower or shorter than this
+	MaxAreaFraction     float64 // bounding rect covering more than this fraction of the image, eg. a background
+}
+
+// all of the detector's visibility tests as one predicate
+func (d Detector) visible() Predicate {
+	predicates := []Predicate{AlphaAbove(d.AlphaThreshold)}
+	if d.LumaThreshold > 0 {
+		predicates = append(predicates, LumaAtLeast(d.LumaThreshold))
+	}
+	if d.Visible != nil {
+		predicates = append(predicates, d.Visible)
+	}
+	if len(predicates) == 1 {
+		return predicates[0]
+	}
+	return All(predicates...)
+}
+
 // identifies pixel islands in an image
-func ImageToIslands(img image.Image, diagonal bool) []image.Rectangle {
-	rects := make([]image.Rectangle, 0)
-	visited := newVisitedArray(img.Bounds())
-	for x := 0; x < img.Bounds().Dx(); x++ {
-		for y := 0; y < img.Bounds().Dy(); y++ {
-			if !visited.get(x, y) && isVisiblePixel(img, x, y) {
-				r := findConnectedPixels(img, x, y, diagonal, visited)
-				rects = append(rects, r)
-			}
-		}
+func (d Detector) Islands(img image.Image) []image.Rectangle {
+	islands, _ := d.Detect(img, false)
+	rects := make([]image.Rectangle, 0, len(islands))
+	for _, island := range islands {
+		rects = append(rects, island.Rectangle)
 	}
 	return rects
 }
 
-// identifies pixel islands in images
-func ImagesToIslands(images []image.Image, diagonal bool) [][]image.Rectangle {
-	boxes := make([][]image.Rectangle, 0, len(images)) // pre-allocate capacity for efficiency
-	for _, img := range images {
-		// For each image, call the functionally complete ImageToIslands
-		islands := ImageToIslands(img, diagonal)
-		boxes = append(boxes, islands)
-	}
-	return boxes
+// identifies pixel islands in an image as Islands does, additionally masking each island's own pixels
+// so that neighbours intruding into its bounding rect can be excluded.
+func (d Detector) IslandMasks(img image.Image) []Island {
+	islands, _ := d.Detect(img, true)
+	return islands
 }
 
-// Given an image and a starting pixel, finds all connected pixels and returns a square encompassing them.
-// 'visited' is used to track progress.
-// the diagonal flag enables checking diagonally connected pixels.
-func findConnectedPixels(img image.Image, x, y int, diagonal bool, visited visitedArray) image.Rectangle {
-	bounds := img.Bounds()
-	stack := []image.Point{{X: x, Y: y}}
-
-	var minX, minY, maxX, maxY int
-	minX = x
-	maxX = x
-	minY = y
-	maxY = y
-
-	for len(stack) > 0 {
-		point := stack[len(stack)-1]
-		stack = stack[:len(stack)-1]
-		visited.set(point.X, point.Y, true)
-		minX = min(minX, point.X)
-		minY = min(minY, point.Y)
-		maxX = max(maxX, point.X)
-		maxY = max(maxY, point.Y)
-
-		// adds pt to the list of pts to check IF it's within bounds
-		// and hasn't been visited yet and is visible.
-		pointCheck := func(pt image.Point) {
-			if !pt.In(bounds) {
-				return
-			}
-			if visited.get(pt.X, pt.Y) {
-				return
-			}
-			if isVisiblePixel(img, pt.X, pt.Y) {
-				stack = append(stack, pt)
-			}
+// identifies pixel islands in an image, returning those kept and those rejected by the size filters.
+// islands are merged before filtering, then nested islands are resolved. islands are only masked if withMasks is set.
+func (d Detector) Detect(img image.Image, withMasks bool) ([]Island, []Island) {
+	found := d.label(img, withMasks)
+	if d.Merge > 0 {
+		found = MergeIslands(found, d.Merge)
+	}
+
+	kept := make([]Island, 0, len(found))
+	rejected := make([]Island, 0)
+	for _, island := range found {
+		if d.RejectReason(island, img.Bounds()) != "" {
+			rejected = append(rejected, island)
+		} else {
+			kept = append(kept, island)
 		}
+	}
+	return ResolveContainment(kept, d.Contain), rejected
+}
 
-		for xOff := -1; xOff <= 1; xOff++ {
-			for yOff := -1; yOff <= 1; yOff++ {
-				if abs(xOff)+abs(yOff) == 2 && !diagonal {
-					continue
-				}
-				pointCheck(image.Point{point.X + xOff, point.Y + yOff})
-			}
+// detects islands in each image as Detect does, using up to d.Workers goroutines across all of them.
+// Results are in image order.
+func (d Detector) DetectAll(images []image.Image, withMasks bool) ([][]Island, [][]Island) {
+	kept := make([][]Island, len(images))
+	rejected := make([][]Island, len(images))
+	// spare workers label bands of each image
+	perImage := d
+	if len(images) > 0 {
+		perImage.Workers = max(1, d.Workers/len(images))
+	}
+	parallel(len(images), d.Workers, func(i int) {
+		kept[i], rejected[i] = perImage.Detect(images[i], withMasks)
+	})
+	return kept, rejected
+}
+
+// explains why the size filters reject an island found within imgBounds, or returns "" if they don't.
+func (d Detector) RejectReason(island Island, imgBounds image.Rectangle) string {
+	switch {
+	case island.Pixels < d.MinPixels:
+		return fmt.Sprintf("%d pixels, fewer than %d", island.Pixels, d.MinPixels)
+	case island.Dx() < d.MinWidth:
+		return fmt.Sprintf("%d wide, narrower than %d", island.Dx(), d.MinWidth)
+	case island.Dy() < d.MinHeight:
+		return fmt.Sprintf("%d tall, shorter than %d", island.Dy(), d.MinHeight)
+	}
+	if d.MaxAreaFraction > 0 && !imgBounds.Empty() {
+		fraction := float64(island.Dx()*island.Dy()) / float64(imgBounds.Dx()*imgBounds.Dy())
+		if fraction > d.MaxAreaFraction {
+			return fmt.Sprintf("covers %.3g of the image, more than %.3g", fraction, d.MaxAreaFraction)
 		}
 	}
-	return image.Rect(minX, minY, maxX, maxY)
+	return ""
+}
+
+// identifies pixel islands in an image, treating any pixel with alpha > 0 as visible
+func ImageToIslands(img image.Image, diagonal bool) []image.Rectangle {
+	return Detector{Diagonal: diagonal}.Islands(img)
 }
 
-func isVisiblePixel(img image.Image, x, y int) bool {
-	_, _, _, a := img.At(x, y).RGBA()
-	return a > 0
+// identifies pixel islands in an image as ImageToIslands does, additionally masking each island's own pixels
+func ImageToIslandMasks(img image.Image, diagonal bool) []Island {
+	return Detector{Diagonal: diagonal}.IslandMasks(img)
 }
 
-/* Originally used an Image.Grey to track visited pixels, however the interface involves too much indirection */
+// identifies pixel islands in images, using every CPU
+func ImagesToIslands(images []image.Image, diagonal bool) [][]image.Rectangle {
+	kept, _ := Detector{Diagonal: diagonal, Workers: runtime.GOMAXPROCS(0)}.DetectAll(images, false)
+	boxes := make([][]image.Rectangle, len(images))
+	for i, islands := range kept {
+		boxes[i] = make([]image.Rectangle, 0, len(islands))
+		for _, island := range islands {
+			boxes[i] = append(boxes[i], island.Rectangle)
+		}
+	}
+	return boxes
+}
+
+/*
+	Originally used an Image.Grey to track visited pixels, however the interface involves too much indirection.
+
+Then a []bool, now one bit per pixel. Each row starts on a new word, so rows can be scanned a word at a time.
+*/
 type visitedArray struct {
-	data []bool
-	w, h int
+	data   []uint64
+	stride int         // words per row
+	origin image.Point // the top left of the bounds covered
 }
 
 func newVisitedArray(bounds image.Rectangle) visitedArray {
 	var va visitedArray
-	va.w = bounds.Dx()
-	va.h = bounds.Dy()
-	va.data = make([]bool, va.w*va.h)
+	va.origin = bounds.Min
+	va.stride = (bounds.Dx() + 63) / 64
+	va.data = make([]uint64, va.stride*bounds.Dy())
 	return va
 }
 
 // nb: no parameter boundary validation
 func (va *visitedArray) get(x, y int) bool {
-	return va.data[(y*va.w)+x]
+	x -= va.origin.X
+	return va.data[(y-va.origin.Y)*va.stride+x/64]&(1<<(x%64)) != 0
 }
 
 // nb: no parameter boundary validation
-func (va *visitedArray) set(x, y int, v bool) {
-	va.data[(y*va.w)+x] = v
+func (va *visitedArray) set(x, y int) {
+	x -= va.origin.X
+	va.data[(y-va.origin.Y)*va.stride+x/64] |= 1 << (x % 64)
 }
 
-func abs[T constraints.Integer](x T) T {
-	if x < 0 {
-		return -x
-	}
-	return x
+// the words of row y
+func (va *visitedArray) row(y int) []uint64 {
+	start := (y - va.origin.Y) * va.stride
+	return va.data[start : start+va.stride]
 }
@@ -3,8 +3,11 @@ package findislands
 import (
 	"bytes"
 	"encoding/base64"
+	"fmt"
 	"image"
+	"image/color"
 	"image/png"
+	"math/rand"
 	"testing"
 )
 
@@ -41,3 +44,348 @@ func TestIslands(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestIslandMasks(t *testing.T) {
+	// an L shaped island with a second island nestled inside its bounding box
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	img := image.NewNRGBA(image.Rect(0, 0, 6, 6))
+	for i := 0; i < 5; i++ {
+		img.Set(0, i, red)
+		img.Set(i, 4, red)
+	}
+	img.Set(2, 1, blue)
+	img.Set(3, 1, blue)
+	img.Set(2, 2, blue)
+
+	islands := ImageToIslandMasks(img, false)
+	rects := ImageToIslands(img, false)
+	if len(islands) != 2 || len(rects) != 2 {
+		t.Fatalf("expected 2 islands, got %d masked and %d unmasked", len(islands), len(rects))
+	}
+	for i, island := range islands {
+		if island.Rectangle != rects[i] || island.Mask.Bounds() != island.Rectangle {
+			t.Errorf("island %d: bounds disagree", i)
+		}
+		col := img.NRGBAAt(island.Min.X, island.Min.Y)
+		for y := island.Min.Y; y < island.Max.Y; y++ {
+			for x := island.Min.X; x < island.Max.X; x++ {
+				member := img.NRGBAAt(x, y) == col
+				if masked := island.Mask.AlphaAt(x, y).A == 255; masked != member {
+					t.Errorf("island %d: pixel %d,%d masked %v, expected %v", i, x, y, masked, member)
+				}
+			}
+		}
+	}
+}
+
+func TestVisibility(t *testing.T) {
+	// two dark grey blocks joined by a faint halo pixel, with a lone speck of alpha noise,
+	// and a white block on black beside them
+	img := image.NewNRGBA(image.Rect(0, 0, 12, 3))
+	for y := 0; y < 3; y++ {
+		img.Set(0, y, color.NRGBA{40, 40, 40, 255})
+		img.Set(2, y, color.NRGBA{40, 40, 40, 255})
+		img.Set(8, y, color.NRGBA{0, 0, 0, 255})
+		img.Set(9, y, color.NRGBA{255, 255, 255, 255})
+	}
+	img.Set(1, 1, color.NRGBA{40, 40, 40, 8})
+	img.Set(5, 1, color.NRGBA{255, 255, 255, 1})
+
+	cases := []struct {
+		visible  Predicate
+		expected int
+	}{
+		{nil, 3},             // halo joins the grey blocks, speck, black+white block
+		{AlphaAbove(8), 3},   // halo and speck gone, grey blocks separate
+		{AlphaAbove(7), 2},   // halo remains
+		{LumaAtLeast(41), 2}, // only the white column and speck
+		{All(AlphaAbove(1), LumaAtLeast(41)), 1},
+		{func(c color.Color) bool { // custom: pure white only
+			return c == color.NRGBA{255, 255, 255, 255}
+		}, 1},
+	}
+	for i, c := range cases {
+		islands := Detector{Visible: c.visible}.Islands(img)
+		if len(islands) != c.expected {
+			t.Errorf("case %d: %d islands, expected %d: %v", i, len(islands), c.expected, islands)
+		}
+	}
+}
+
+func TestSizeFilters(t *testing.T) {
+	// a 1x1 speck, a 6x1 line, a 3x3 L of 5 pixels and a 8x8 block, on a 20x10 image
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	white := color.NRGBA{255, 255, 255, 255}
+	img.Set(0, 0, white)
+	for x := 2; x < 8; x++ {
+		img.Set(x, 0, white)
+	}
+	for i := 0; i < 3; i++ {
+		img.Set(0, 2+i, white)
+		img.Set(i, 4, white)
+	}
+	for y := 1; y < 9; y++ {
+		for x := 10; x < 18; x++ {
+			img.Set(x, y, white)
+		}
+	}
+
+	cases := []struct {
+		detector         Detector
+		kept, rejected   int
+		rejectedPixelSum int
+	}{
+		{Detector{}, 4, 0, 0},
+		{Detector{MinPixels: 2}, 3, 1, 1},
+		{Detector{MinPixels: 6}, 2, 2, 1 + 5},
+		{Detector{MinHeight: 2}, 2, 2, 1 + 6},
+		{Detector{MinWidth: 4}, 2, 2, 1 + 5},
+		{Detector{MaxAreaFraction: 0.25}, 3, 1, 64},
+		{Detector{MinPixels: 2, MaxAreaFraction: 0.25}, 2, 2, 1 + 64},
+	}
+	for i, c := range cases {
+		for _, withMasks := range []bool{false, true} {
+			kept, rejected := c.detector.Detect(img, withMasks)
+			if len(kept) != c.kept || len(rejected) != c.rejected {
+				t.Errorf("case %d: kept %d and rejected %d, expected %d and %d", i, len(kept), len(rejected), c.kept, c.rejected)
+				continue
+			}
+			sum := 0
+			for _, island := range rejected {
+				sum += island.Pixels
+				if c.detector.RejectReason(island, img.Bounds()) == "" {
+					t.Errorf("case %d: rejected island %v has no reason", i, island.Rectangle)
+				}
+				if (island.Mask != nil) != withMasks {
+					t.Errorf("case %d: rejected island %v masked %v", i, island.Rectangle, island.Mask != nil)
+				}
+			}
+			if sum != c.rejectedPixelSum {
+				t.Errorf("case %d: rejected %d pixels, expected %d", i, sum, c.rejectedPixelSum)
+			}
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	// 2x2 blocks: two with a 2 pixel gap between them, and a diagonal neighbour of the second
+	img := image.NewNRGBA(image.Rect(0, 0, 12, 12))
+	white := color.NRGBA{255, 255, 255, 255}
+	for _, p := range []image.Point{{0, 0}, {4, 0}, {6, 2}} {
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 2; x++ {
+				img.Set(p.X+x, p.Y+y, white)
+			}
+		}
+	}
+	cases := []struct {
+		detector Detector
+		expected int
+	}{
+		{Detector{}, 3},
+		{Detector{Diagonal: true}, 2},
+		{Detector{Merge: 1}, 2},
+		{Detector{Merge: 2}, 2},
+		{Detector{Merge: 3}, 1},
+	}
+	for i, c := range cases {
+		islands := c.detector.IslandMasks(img)
+		if len(islands) != c.expected {
+			t.Errorf("case %d: %d islands, expected %d", i, len(islands), c.expected)
+		}
+		checkIslandCoverage(t, fmt.Sprintf("case %d", i), img, islands)
+	}
+
+	// merged islands must be further apart than the distance, and keep every pixel
+	rng := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 50; trial++ {
+		islands := make([]Island, 1+rng.Intn(40))
+		pixels := 0
+		for i := range islands {
+			x, y := rng.Intn(100), rng.Intn(100)
+			islands[i] = Island{Rectangle: image.Rect(x, y, x+1+rng.Intn(8), y+1+rng.Intn(8)), Pixels: 1 + rng.Intn(10)}
+			pixels += islands[i].Pixels
+		}
+		distance := 1 + rng.Intn(6)
+		merged := MergeIslands(islands, distance)
+		for _, island := range merged {
+			pixels -= island.Pixels
+		}
+		if pixels != 0 {
+			t.Errorf("trial %d: pixel count changed by %d", trial, -pixels)
+		}
+		for i, a := range merged {
+			for _, b := range merged[i+1:] {
+				if a.Inset(-distance).Overlaps(b.Rectangle) {
+					t.Errorf("trial %d: %v and %v within %d of each other", trial, a.Rectangle, b.Rectangle, distance)
+				}
+			}
+		}
+		for _, island := range islands {
+			contained := false
+			for _, m := range merged {
+				contained = contained || island.In(m.Rectangle)
+			}
+			if !contained {
+				t.Errorf("trial %d: %v lost", trial, island.Rectangle)
+			}
+		}
+	}
+}
+
+func TestContainment(t *testing.T) {
+	// shapes.png has an island inside an L's bounding box, and another inside a hollow square
+	img := loadPNG(t, "testdata/shapes.png")
+	all := ImageToIslandMasks(img, false)
+
+	for _, policy := range []Containment{ContainKeep, ContainWarn, ContainAbsorb} {
+		islands := Detector{Contain: policy}.IslandMasks(img)
+		nested := 0
+		for _, island := range islands {
+			nested += len(island.Nested)
+			for _, n := range island.Nested {
+				if !n.In(island.Rectangle) {
+					t.Errorf("%v: %v recorded as nested within %v", policy, n, island.Rectangle)
+				}
+			}
+		}
+		expectedNested, expectedCount := 2, len(all)
+		switch policy {
+		case ContainKeep:
+			expectedNested = 0
+		case ContainAbsorb:
+			expectedCount -= 2
+		}
+		if nested != expectedNested || len(islands) != expectedCount {
+			t.Errorf("%v: %d islands with %d nested, expected %d with %d", policy, len(islands), nested, expectedCount, expectedNested)
+		}
+		if policy == ContainAbsorb {
+			// absorbed pixels now belong to their container's mask
+			checkIslandCoverage(t, policy.String(), img, islands)
+		}
+	}
+
+	// the caller's masks aren't modified
+	again := ImageToIslandMasks(img, false)
+	ResolveContainment(all, ContainAbsorb)
+	for i := range all {
+		if !bytes.Equal(all[i].Mask.Pix, again[i].Mask.Pix) {
+			t.Errorf("island %d's mask was modified", i)
+		}
+	}
+}
+
+func TestParallelDeterministic(t *testing.T) {
+	// random noise, dense enough that many islands span several bands
+	rng := rand.New(rand.NewSource(3))
+	img := image.NewNRGBA(image.Rect(0, 0, 257, 1031))
+	for i := 3; i < len(img.Pix); i += 4 {
+		if rng.Intn(100) < 45 {
+			img.Pix[i] = 255
+		}
+	}
+	for _, diagonal := range []bool{false, true} {
+		expected := Detector{Diagonal: diagonal}.IslandMasks(img)
+		for _, workers := range []int{2, 3, 8, 64} {
+			islands := Detector{Diagonal: diagonal, Workers: workers}.IslandMasks(img)
+			if len(islands) != len(expected) {
+				t.Fatalf("diagonal %v, %d workers: %d islands, expected %d", diagonal, workers, len(islands), len(expected))
+			}
+			for i := range islands {
+				if islands[i].Rectangle != expected[i].Rectangle || islands[i].Pixels != expected[i].Pixels ||
+					!bytes.Equal(islands[i].Mask.Pix, expected[i].Mask.Pix) {
+					t.Fatalf("diagonal %v, %d workers: island %d differs", diagonal, workers, i)
+				}
+			}
+		}
+	}
+
+	images := []image.Image{img, loadPNG(t, "testdata/shapes.png"), loadPNG(t, "testdata/edges.png")}
+	all := ImagesToIslands(images, false)
+	for i, img := range images {
+		if fmt.Sprint(all[i]) != fmt.Sprint(ImageToIslands(img, false)) {
+			t.Errorf("image %d: ImagesToIslands differs from ImageToIslands", i)
+		}
+	}
+}
+
+func TestScanners(t *testing.T) {
+	// the typed scanners must agree with the generic img.At path for every threshold
+	rng := rand.New(rand.NewSource(4))
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 131, 7))
+	for i := range nrgba.Pix {
+		nrgba.Pix[i] = uint8(rng.Intn(256))
+	}
+	rgba := image.NewRGBA(nrgba.Rect)
+	gray := image.NewGray(nrgba.Rect)
+	paletted := image.NewPaletted(nrgba.Rect, color.Palette{color.Transparent, color.White, color.NRGBA{200, 10, 10, 100}})
+	for y := 0; y < nrgba.Rect.Dy(); y++ {
+		for x := 0; x < nrgba.Rect.Dx(); x++ {
+			rgba.Set(x, y, nrgba.At(x, y))
+			gray.Set(x, y, nrgba.At(x, y))
+			// includes an index outside the palette
+			paletted.SetColorIndex(x, y, uint8(rng.Intn(4)))
+		}
+	}
+	images := []image.Image{nrgba, rgba, gray, paletted, nrgba.SubImage(image.Rect(5, 2, 100, 6))}
+
+	detectors := []Detector{{}, {AlphaThreshold: 100}, {LumaThreshold: 128}, {AlphaThreshold: 30, LumaThreshold: 60},
+		{Visible: func(c color.Color) bool { r, _, _, _ := c.RGBA(); return r > 0x8000 }}}
+	for i, img := range images {
+		for j, d := range detectors {
+			fast := d.scanVisible(img, img.Bounds())
+			b := img.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					var expected bool
+					if p, ok := img.(*image.Paletted); ok && int(p.ColorIndexAt(x, y)) >= len(p.Palette) {
+						expected = false
+					} else {
+						expected = d.visible()(img.At(x, y))
+					}
+					if fast.get(x, y) != expected {
+						t.Fatalf("image %d, detector %d: pixel %d,%d visible %v, expected %v", i, j, x, y, fast.get(x, y), expected)
+					}
+				}
+			}
+		}
+	}
+}
+
+// presents an image as if its top left were at the origin, via the generic img.At path
+type shiftedImage struct {
+	image.Image
+}
+
+func (s shiftedImage) Bounds() image.Rectangle {
+	b := s.Image.Bounds()
+	return b.Sub(b.Min)
+}
+
+func (s shiftedImage) At(x, y int) color.Color {
+	return s.Image.At(x+s.Image.Bounds().Min.X, y+s.Image.Bounds().Min.Y)
+}
+
+func TestSubImages(t *testing.T) {
+	type subImager interface {
+		SubImage(r image.Rectangle) image.Image
+	}
+	r := image.Rect(37, 23, 171, 160)
+	for _, kind := range []string{"nrgba", "rgba", "paletted", "gray"} {
+		sub := benchImage(200, kind).(subImager).SubImage(r)
+		for _, workers := range []int{1, 3} {
+			d := Detector{Diagonal: true, Workers: workers, LumaThreshold: 1}
+			islands := d.IslandMasks(sub)
+			expected := d.IslandMasks(shiftedImage{sub})
+			if len(islands) != len(expected) || len(islands) == 0 {
+				t.Fatalf("%s, %d workers: %d islands, expected %d", kind, workers, len(islands), len(expected))
+			}
+			for i := range islands {
+				if !islands[i].In(r) || islands[i].Rectangle != expected[i].Add(r.Min) ||
+					islands[i].Mask.Rect != islands[i].Rectangle || !bytes.Equal(islands[i].Mask.Pix, expected[i].Mask.Pix) {
+					t.Fatalf("%s, %d workers: island %d at %v, expected %v", kind, workers, i, islands[i].Rectangle, expected[i].Add(r.Min))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package findislands
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func loadPNG(t *testing.T, path string) image.Image {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// detects islands in every testdata png, comparing their rects against the .golden files
+// and checking each island's mask covers exactly its own pixels.
+func TestGoldenIslands(t *testing.T) {
+	paths, err := filepath.Glob("testdata/*.png")
+	if err != nil || len(paths) == 0 {
+		t.Fatal("no test images found", err)
+	}
+	for _, path := range paths {
+		img := loadPNG(t, path)
+		for _, diagonal := range []bool{false, true} {
+			golden := strings.TrimSuffix(path, ".png")
+			if diagonal {
+				golden += ".diagonal"
+			}
+			golden += ".golden"
+
+			islands := ImageToIslandMasks(img, diagonal)
+			var sb strings.Builder
+			for _, island := range islands {
+				fmt.Fprintf(&sb, "%d,%d,%d,%d\n", island.Min.X, island.Min.Y, island.Max.X, island.Max.Y)
+			}
+			if *update {
+				if err := os.WriteFile(golden, []byte(sb.String()), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sb.String() != string(expected) {
+				t.Errorf("%s: islands differ from golden file\ngot:\n%sexpected:\n%s", golden, sb.String(), expected)
+			}
+
+			rects := ImageToIslands(img, diagonal)
+			if len(rects) != len(islands) {
+				t.Fatalf("%s: %d masked islands but %d rects", golden, len(islands), len(rects))
+			}
+			checkIslandCoverage(t, golden, img, islands)
+			for i := range rects {
+				if rects[i] != islands[i].Rectangle {
+					t.Errorf("%s: island %d rect %v differs from masked rect %v", golden, i, rects[i], islands[i].Rectangle)
+				}
+			}
+		}
+	}
+}
+
+// every visible pixel must belong to exactly one island, and each island's rect must be tight around its mask.
+func checkIslandCoverage(t *testing.T, name string, img image.Image, islands []Island) {
+	t.Helper()
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			owners := 0
+			for _, island := range islands {
+				if island.Mask.AlphaAt(x, y).A > 0 {
+					owners++
+				}
+			}
+			if visible := DefaultVisible(img.At(x, y)); (visible && owners != 1) || (!visible && owners != 0) {
+				t.Errorf("%s: pixel %d,%d (visible %v) belongs to %d islands", name, x, y, visible, owners)
+			}
+		}
+	}
+	for i, island := range islands {
+		var used image.Rectangle
+		count := 0
+		for y := island.Min.Y; y < island.Max.Y; y++ {
+			for x := island.Min.X; x < island.Max.X; x++ {
+				if island.Mask.AlphaAt(x, y).A > 0 {
+					used = used.Union(image.Rect(x, y, x+1, y+1))
+					count++
+				}
+			}
+		}
+		if count != island.Pixels {
+			t.Errorf("%s: island %d counted %d pixels, but its mask has %d", name, i, island.Pixels, count)
+		}
+		if used != island.Rectangle {
+			t.Errorf("%s: island %d rect %v, but its pixels span %v", name, i, island.Rectangle, used)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package findislands
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+/*
+Connected component labelling, optionally in parallel.
+The image is split into horizontal bands, each flood filled independently over a bitmap of its visible pixels
+(see scan.go). Components meeting at the seams
+between bands are then joined with union-find. Islands are ordered by their first pixel in a column by column scan,
+which is the order a single flood fill over the whole image finds them in, so the result never depends on the banding.
+*/
+
+// bands shorter than this aren't worth a goroutine
+const minBandHeight = 64
+
+// an island found within a single band
+type component struct {
+	Island
+	first image.Point // first pixel in column by column scan order
+}
+
+// the components of one band, along with which touch its top and bottom rows
+type bandLabels struct {
+	components  []component
+	top, bottom []int // component index per column of the band's first and last rows, -1 where not visible
+}
+
+// finds every island in img, labelling bands of it in parallel if d.Workers > 1.
+func (d Detector) label(img image.Image, withMasks bool) []Island {
+	bands := splitBands(img.Bounds(), d.Workers)
+	labels := make([]bandLabels, len(bands))
+	parallel(len(bands), d.Workers, func(i int) {
+		labels[i] = d.labelBand(img, bands[i], withMasks, len(bands) > 1)
+	})
+
+	if len(bands) == 1 {
+		return sortComponents(labels[0].components)
+	}
+
+	// join components across each seam
+	offsets := make([]int, len(labels))
+	total := 0
+	for i, l := range labels {
+		offsets[i] = total
+		total += len(l.components)
+	}
+	uf := newUnionFind(total)
+	reach := 0
+	if d.Diagonal {
+		reach = 1
+	}
+	for i := 0; i+1 < len(labels); i++ {
+		above, below := labels[i].bottom, labels[i+1].top
+		for x, a := range above {
+			if a < 0 {
+				continue
+			}
+			for bx := max(0, x-reach); bx <= min(len(below)-1, x+reach); bx++ {
+				if below[bx] >= 0 {
+					uf.union(offsets[i]+a, offsets[i+1]+below[bx])
+				}
+			}
+		}
+	}
+
+	// combine each set of joined components
+	groups := make(map[int][]component)
+	for i, l := range labels {
+		for j, c := range l.components {
+			root := uf.find(offsets[i] + j)
+			groups[root] = append(groups[root], c)
+		}
+	}
+	joined := make([]component, 0, len(groups))
+	for _, g := range groups {
+		parts := make([]Island, 0, len(g))
+		first := g[0].first
+		for _, c := range g {
+			parts = append(parts, c.Island)
+			if scansBefore(c.first, first) {
+				first = c.first
+			}
+		}
+		joined = append(joined, component{Island: combineIslands(parts), first: first})
+	}
+	return sortComponents(joined)
+}
+
+// orders components by their first pixel, returning their islands
+func sortComponents(components []component) []Island {
+	sort.Slice(components, func(a, b int) bool {
+		return scansBefore(components[a].first, components[b].first)
+	})
+	islands := make([]Island, 0, len(components))
+	for _, c := range components {
+		islands = append(islands, c.Island)
+	}
+	return islands
+}
+
+// flood fills every island within band.
+// seams records which components touch the band's top and bottom rows.
+func (d Detector) labelBand(img image.Image, band image.Rectangle, withMasks, seams bool) bandLabels {
+	var l bandLabels
+	if seams {
+		l.top = make([]int, band.Dx())
+		l.bottom = make([]int, band.Dx())
+		for i := range l.top {
+			l.top[i], l.bottom[i] = -1, -1
+		}
+	}
+	f := filler{
+		bounds:   band,
+		diagonal: d.Diagonal,
+		solid:    d.scanVisible(img, band),
+		visited:  newVisitedArray(band),
+	}
+	var pixels *[]image.Point
+	if withMasks || seams {
+		pixels = new([]image.Point)
+	}
+
+	// row by row, a word at a time, for any visible pixel not yet visited
+	for y := band.Min.Y; y < band.Max.Y; y++ {
+		solid, visited := f.solid.row(y), f.visited.row(y)
+		for w := range solid {
+			for {
+				unvisited := solid[w] &^ visited[w]
+				if unvisited == 0 {
+					break
+				}
+				x := band.Min.X + w*64 + bits.TrailingZeros64(unvisited)
+				if pixels != nil {
+					*pixels = (*pixels)[:0]
+				}
+				c := f.findConnectedPixels(x, y, pixels)
+				if withMasks {
+					c.Mask = image.NewAlpha(c.Rectangle)
+					for _, p := range *pixels {
+						c.Mask.SetAlpha(p.X, p.Y, color.Alpha{255})
+					}
+				}
+				if seams {
+					for _, p := range *pixels {
+						if p.Y == band.Min.Y {
+							l.top[p.X-band.Min.X] = len(l.components)
+						}
+						if p.Y == band.Max.Y-1 {
+							l.bottom[p.X-band.Min.X] = len(l.components)
+						}
+					}
+				}
+				l.components = append(l.components, c)
+			}
+		}
+	}
+	return l
+}
+
+// flood fill state for one band
+type filler struct {
+	bounds   image.Rectangle
+	diagonal bool
+	solid    visitedArray // which pixels are visible
+	visited  visitedArray
+	stack    []image.Point // reused between islands
+}
+
+var orthogonal = [...]image.Point{{0, -1}, {-1, 0}, {1, 0}, {0, 1}}
+var allNeighbours = [...]image.Point{{-1, -1}, {0, -1}, {1, -1}, {-1, 0}, {1, 0}, {-1, 1}, {0, 1}, {1, 1}}
+
+// Given a starting pixel, finds all connected pixels within the band and returns a square encompassing them,
+// along with their number and the first of them in column by column scan order.
+// if pixels is non-nil, every connected pixel is appended to it.
+func (f *filler) findConnectedPixels(x, y int, pixels *[]image.Point) component {
+	f.stack = append(f.stack[:0], image.Point{X: x, Y: y})
+	f.visited.set(x, y)
+	c := component{first: image.Pt(x, y)}
+	neighbours := orthogonal[:]
+	if f.diagonal {
+		neighbours = allNeighbours[:]
+	}
+
+	var minX, minY, maxX, maxY int
+	minX = x
+	maxX = x
+	minY = y
+	maxY = y
+
+	for len(f.stack) > 0 {
+		point := f.stack[len(f.stack)-1]
+		f.stack = f.stack[:len(f.stack)-1]
+		c.Pixels++
+		if pixels != nil {
+			*pixels = append(*pixels, point)
+		}
+		if scansBefore(point, c.first) {
+			c.first = point
+		}
+		minX = min(minX, point.X)
+		minY = min(minY, point.Y)
+		maxX = max(maxX, point.X)
+		maxY = max(maxY, point.Y)
+
+		for _, off := range neighbours {
+			// adds pt to the list of pts to check IF it's within bounds
+			// and hasn't been visited yet and is visible.
+			// pixels are marked visited as they're added, so each is added only once.
+			pt := point.Add(off)
+			if pt.In(f.bounds) && f.solid.get(pt.X, pt.Y) && !f.visited.get(pt.X, pt.Y) {
+				f.visited.set(pt.X, pt.Y)
+				f.stack = append(f.stack, pt)
+			}
+		}
+	}
+	// Max is exclusive
+	c.Rectangle = image.Rect(minX, minY, maxX+1, maxY+1)
+	return c
+}
+
+// true if a comes before b when scanning column by column, top to bottom
+func scansBefore(a, b image.Point) bool {
+	return a.X < b.X || (a.X == b.X && a.Y < b.Y)
+}
+
+// splits bounds into horizontal bands, a few per worker so uneven bands balance out.
+func splitBands(bounds image.Rectangle, workers int) []image.Rectangle {
+	n := min(workers*4, bounds.Dy()/minBandHeight)
+	if workers <= 1 || n <= 1 {
+		return []image.Rectangle{bounds}
+	}
+	bands := make([]image.Rectangle, n)
+	for i := range bands {
+		bands[i] = bounds
+		bands[i].Min.Y = bounds.Min.Y + bounds.Dy()*i/n
+		bands[i].Max.Y = bounds.Min.Y + bounds.Dy()*(i+1)/n
+	}
+	return bands
+}
+
+// calls fn for every index in [0, n) using up to workers goroutines.
+// with workers <= 1 or a single index, fn runs on the calling goroutine.
+func parallel(n, workers int, fn func(i int)) {
+	if workers <= 1 || n <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+}
+
+// disjoint sets of indices, the lowest index of a set being its root
+type unionFind []int
+
+func newUnionFind(n int) unionFind {
+	u := make(unionFind, n)
+	for i := range u {
+		u[i] = i
+	}
+	return u
+}
+
+func (u unionFind) find(i int) int {
+	for u[i] != i {
+		u[i] = u[u[i]] // path halving
+		i = u[i]
+	}
+	return i
+}
+
+func (u unionFind) union(a, b int) {
+	ra, rb := u.find(a), u.find(b)
+	u[max(ra, rb)] = min(ra, rb)
+}
@@ -0,0 +1,87 @@
+package findislands
+
+import (
+	"image"
+	"image/draw"
+	"sort"
+)
+
+// joins islands whose bounding rects come within distance pixels of each other, measured as
+// the larger of the horizontal and vertical distance between their nearest pixels (1 = touching, including diagonally),
+// so that sprites with detached parts such as weapons or particles stay whole.
+// Repeats until no merged rects lie within distance of each other, so the results never overlap.
+// Masks, if present, are combined. The merged island takes the place of its first member.
+func MergeIslands(islands []Island, distance int) []Island {
+	for {
+		merged := mergePass(islands, distance)
+		if len(merged) == len(islands) {
+			return merged
+		}
+		islands = merged
+	}
+}
+
+// a single round of union-find over the islands' rects
+func mergePass(islands []Island, distance int) []Island {
+	uf := newUnionFind(len(islands))
+
+	// sweep in order of left edge, so only rects starting within reach of each one's right edge need checking
+	order := make([]int, len(islands))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return islands[order[a]].Min.X < islands[order[b]].Min.X
+	})
+	for a, i := range order {
+		reach := islands[i].Inset(-distance)
+		for _, j := range order[a+1:] {
+			if islands[j].Min.X >= reach.Max.X {
+				break
+			}
+			if reach.Overlaps(islands[j].Rectangle) {
+				// the lower index becomes the root, keeping detection order
+				uf.union(i, j)
+			}
+		}
+	}
+
+	groups := make(map[int][]Island)
+	roots := make([]int, 0)
+	for i := range islands {
+		r := uf.find(i)
+		if _, ok := groups[r]; !ok {
+			roots = append(roots, r)
+		}
+		groups[r] = append(groups[r], islands[i])
+	}
+	sort.Ints(roots)
+	merged := make([]Island, 0, len(roots))
+	for _, r := range roots {
+		merged = append(merged, combineIslands(groups[r]))
+	}
+	return merged
+}
+
+// a single island covering all of the given islands
+func combineIslands(islands []Island) Island {
+	if len(islands) == 1 {
+		return islands[0]
+	}
+	var combined Island
+	masked := false
+	for _, island := range islands {
+		combined.Rectangle = combined.Union(island.Rectangle)
+		combined.Pixels += island.Pixels
+		masked = masked || island.Mask != nil
+	}
+	if masked {
+		combined.Mask = image.NewAlpha(combined.Rectangle)
+		for _, island := range islands {
+			if island.Mask != nil {
+				draw.Draw(combined.Mask, island.Rectangle, island.Mask, island.Min, draw.Over)
+			}
+		}
+	}
+	return combined
+}
@@ -0,0 +1,106 @@
+package findislands
+
+import (
+	"image"
+	"image/color"
+)
+
+/*
+Builds a bitmap of which pixels are visible, row by row.
+Common image types are read straight from their pixel data, avoiding a color.Color per pixel.
+Paletted and greyscale images have few enough colours that each is tested once via a lookup table,
+whatever the predicate. Other types, or a custom predicate, fall back to img.At.
+*/
+
+// returns a bitmap over band of which pixels pass the detector's visibility tests
+func (d Detector) scanVisible(img image.Image, band image.Rectangle) visitedArray {
+	solid := newVisitedArray(band)
+	switch src := img.(type) {
+	case *image.NRGBA:
+		if d.Visible == nil {
+			scanNRGBA(src.Pix, src.Stride, src.PixOffset(band.Min.X, band.Min.Y), band, d.AlphaThreshold, d.LumaThreshold, false, &solid)
+			return solid
+		}
+	case *image.RGBA:
+		if d.Visible == nil {
+			scanNRGBA(src.Pix, src.Stride, src.PixOffset(band.Min.X, band.Min.Y), band, d.AlphaThreshold, d.LumaThreshold, true, &solid)
+			return solid
+		}
+	case *image.Paletted:
+		var table [256]bool
+		visible := d.visible()
+		for i, c := range src.Palette {
+			if i < len(table) {
+				table[i] = visible(c)
+			}
+		}
+		scanIndexed(src.Pix, src.Stride, src.PixOffset(band.Min.X, band.Min.Y), band, &table, &solid)
+		return solid
+	case *image.Gray:
+		var table [256]bool
+		visible := d.visible()
+		for i := range table {
+			table[i] = visible(color.Gray{uint8(i)})
+		}
+		scanIndexed(src.Pix, src.Stride, src.PixOffset(band.Min.X, band.Min.Y), band, &table, &solid)
+		return solid
+	}
+
+	visible := d.visible()
+	for y := band.Min.Y; y < band.Max.Y; y++ {
+		row := solid.row(y)
+		for x := band.Min.X; x < band.Max.X; x++ {
+			if visible(img.At(x, y)) {
+				i := x - band.Min.X
+				row[i/64] |= 1 << (i % 64)
+			}
+		}
+	}
+	return solid
+}
+
+// scans 4 byte per pixel RGBA data, starting at offset, which is alpha premultiplied if premultiplied.
+// matches AlphaAbove and LumaAtLeast exactly.
+func scanNRGBA(pix []uint8, stride, offset int, band image.Rectangle, alpha, luma uint8, premultiplied bool, solid *visitedArray) {
+	for y := band.Min.Y; y < band.Max.Y; y++ {
+		row := solid.row(y)
+		p := pix[offset : offset+band.Dx()*4]
+		for i := 0; i < band.Dx(); i++ {
+			r, g, b, a := p[i*4], p[i*4+1], p[i*4+2], p[i*4+3]
+			if a <= alpha {
+				continue
+			}
+			if luma > 0 {
+				if premultiplied {
+					r, g, b = unpremultiply(r, a), unpremultiply(g, a), unpremultiply(b, a)
+				}
+				if luma8(r, g, b) < int(luma) {
+					continue
+				}
+			}
+			row[i/64] |= 1 << (i % 64)
+		}
+		offset += stride
+	}
+}
+
+// scans 1 byte per pixel data, starting at offset, via a table of which values are visible
+func scanIndexed(pix []uint8, stride, offset int, band image.Rectangle, table *[256]bool, solid *visitedArray) {
+	for y := band.Min.Y; y < band.Max.Y; y++ {
+		row := solid.row(y)
+		for i, v := range pix[offset : offset+band.Dx()] {
+			if table[v] {
+				row[i/64] |= 1 << (i % 64)
+			}
+		}
+		offset += stride
+	}
+}
+
+// undoes alpha premultiplication as color.NRGBAModel does. a must be > 0.
+func unpremultiply(v, a uint8) uint8 {
+	if a == 0xff {
+		return v
+	}
+	return uint8((uint32(v) * 0x101 * 0xffff / (uint32(a) * 0x101)) >> 8)
+}
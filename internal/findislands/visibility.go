@@ -0,0 +1,48 @@
+package findislands
+
+import "image/color"
+
+// decides whether a pixel of the given colour belongs to an island
+type Predicate func(c color.Color) bool
+
+// any pixel that isn't fully transparent
+var DefaultVisible Predicate = AlphaAbove(0)
+
+// pixels with alpha above threshold (0-255), so faint halos and alpha noise can be ignored
+func AlphaAbove(threshold uint8) Predicate {
+	// compare at 16 bits so partial alpha between 8 bit steps isn't lost
+	t := uint32(threshold) * 0x101
+	return func(c color.Color) bool {
+		_, _, _, a := c.RGBA()
+		return a > t
+	}
+}
+
+// pixels whose luminance (0-255, ignoring alpha) is at least threshold, for dark backgrounds
+func LumaAtLeast(threshold uint8) Predicate {
+	return func(c color.Color) bool {
+		return luma(c) >= int(threshold)
+	}
+}
+
+// pixels satisfying every predicate
+func All(predicates ...Predicate) Predicate {
+	return func(c color.Color) bool {
+		for _, p := range predicates {
+			if !p(c) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Rec. 601 luma of the unpremultiplied colour
+func luma(c color.Color) int {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return luma8(n.R, n.G, n.B)
+}
+
+func luma8(r, g, b uint8) int {
+	return (299*int(r) + 587*int(g) + 114*int(b) + 500) / 1000
+}
@@ -0,0 +1,62 @@
+// package for splitting animated GIFs into whole frames, as they'd be displayed.
+package gifframes
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+// decodes every frame of a GIF, see Compose.
+func Decode(r io.Reader) ([]*image.NRGBA, error) {
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error whilst decoding gif frames: %w", err)
+	}
+	return Compose(g), nil
+}
+
+// composes each frame of g onto the logical screen, honouring the disposal of the frames before it.
+// frames in a GIF are often only the pixels which changed, so on their own they aren't what's shown.
+// as browsers do, the background is disposed to transparent rather than the background colour.
+func Compose(g *gif.GIF) []*image.NRGBA {
+	screen := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if screen.Empty() {
+		// no logical screen size given, so make room for every frame
+		for _, frame := range g.Image {
+			screen = screen.Union(frame.Bounds())
+		}
+	}
+
+	canvas := image.NewNRGBA(screen)
+	frames := make([]*image.NRGBA, 0, len(g.Image))
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = clone(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		frames = append(frames, clone(canvas))
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames
+}
+
+func clone(img *image.NRGBA) *image.NRGBA {
+	c := *img
+	c.Pix = append([]uint8(nil), img.Pix...)
+	return &c
+}
@@ -0,0 +1,96 @@
+package gifframes
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+var (
+	clear = color.NRGBA{}
+	red   = color.NRGBA{255, 0, 0, 255}
+	green = color.NRGBA{0, 255, 0, 255}
+	blue  = color.NRGBA{0, 0, 255, 255}
+)
+
+var palette = color.Palette{clear, red, green, blue}
+
+// a frame covering r, filled with the palette index fill, then any overrides
+func frame(r image.Rectangle, fill uint8, overrides map[image.Point]uint8) *image.Paletted {
+	img := image.NewPaletted(r, palette)
+	for i := range img.Pix {
+		img.Pix[i] = fill
+	}
+	for p, c := range overrides {
+		img.SetColorIndex(p.X, p.Y, c)
+	}
+	return img
+}
+
+func testGIF() *gif.GIF {
+	return &gif.GIF{
+		Image: []*image.Paletted{
+			frame(image.Rect(0, 0, 4, 2), 1, nil),
+			frame(image.Rect(0, 0, 2, 1), 2, nil),
+			frame(image.Rect(2, 0, 4, 1), 3, map[image.Point]uint8{{3, 0}: 0}),
+			frame(image.Rect(0, 1, 1, 2), 2, nil),
+		},
+		Delay:    []int{10, 10, 10, 10},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, gif.DisposalNone},
+		Config:   image.Config{ColorModel: palette, Width: 4, Height: 2},
+	}
+}
+
+// the expected frames of testGIF, row by row
+var want = [][2][4]color.NRGBA{
+	{{red, red, red, red}, {red, red, red, red}},
+	// only the changed pixels are in the frame, the rest show through
+	{{green, green, red, red}, {red, red, red, red}},
+	// the previous frame's area was cleared, the transparent pixel shows what's beneath
+	{{clear, clear, blue, red}, {red, red, red, red}},
+	// the blue frame is undone
+	{{clear, clear, red, red}, {green, red, red, red}},
+}
+
+func checkFrames(t *testing.T, frames []*image.NRGBA) {
+	t.Helper()
+	if len(frames) != len(want) {
+		t.Fatalf("got %d frames, want %d", len(frames), len(want))
+	}
+	for i, f := range frames {
+		if f.Bounds() != image.Rect(0, 0, 4, 2) {
+			t.Fatalf("frame %d: bounds %v, want the logical screen", i, f.Bounds())
+		}
+		for y := range 2 {
+			for x := range 4 {
+				if got := f.NRGBAAt(x, y); got != want[i][y][x] {
+					t.Errorf("frame %d (%d,%d): got %v, want %v", i, x, y, got, want[i][y][x])
+				}
+			}
+		}
+	}
+}
+
+func TestCompose(t *testing.T) {
+	checkFrames(t, Compose(testGIF()))
+}
+
+func TestDecode(t *testing.T) {
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, testGIF()); err != nil {
+		t.Fatal(err)
+	}
+	frames, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFrames(t, frames)
+}
+
+func TestComposeWithoutScreenSize(t *testing.T) {
+	g := testGIF()
+	g.Config = image.Config{}
+	checkFrames(t, Compose(g))
+}
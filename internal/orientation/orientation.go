@@ -0,0 +1,90 @@
+// package for describing how stored pixels are oriented relative to how they're displayed,
+// as atlas regions may be stored rotated to pack more tightly.
+package orientation
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// how pixels are stored relative to their upright (displayed) form: mirrored horizontally if Flip,
+// then rotated counter-clockwise by Rotate degrees. The zero value is upright.
+type Orientation struct {
+	Rotate int  // 0, 90, 180 or 270
+	Flip   bool // mirrored horizontally before rotating
+}
+
+// upright, needing no transformation to display
+var Upright = Orientation{}
+
+// an orientation rotated counter-clockwise by degrees, which must be a multiple of 90. Negative degrees are clockwise.
+func Rotated(degrees int) (Orientation, error) {
+	if degrees%90 != 0 {
+		return Upright, fmt.Errorf("invalid rotation %d, should be a multiple of 90 degrees", degrees)
+	}
+	return Orientation{Rotate: normalise(degrees)}, nil
+}
+
+// degrees as 0, 90, 180 or 270
+func normalise(degrees int) int {
+	return ((degrees % 360) + 360) % 360
+}
+
+// true if the stored pixels' width and height are the upright's height and width
+func (o Orientation) SwapsAxes() bool {
+	return o.Rotate == 90 || o.Rotate == 270
+}
+
+// the dimensions of the stored pixels, given the upright dimensions
+func (o Orientation) StoredSize(upright image.Point) image.Point {
+	if o.SwapsAxes() {
+		return image.Pt(upright.Y, upright.X)
+	}
+	return upright
+}
+
+// the orientation reached by applying o, then p
+func (o Orientation) Then(p Orientation) Orientation {
+	// mirroring reverses the direction of any rotation before it
+	degrees := o.Rotate
+	if p.Flip {
+		degrees = -degrees
+	}
+	return Orientation{Rotate: normalise(degrees + p.Rotate), Flip: o.Flip != p.Flip}
+}
+
+// the orientation undoing o, so o.Then(o.Inverse()) is upright
+func (o Orientation) Inverse() Orientation {
+	if o.Flip {
+		// every mirror is its own inverse
+		return o
+	}
+	return Orientation{Rotate: normalise(-o.Rotate)}
+}
+
+// transforms upright pixels into their stored form.
+// to display stored pixels, apply the inverse.
+func (o Orientation) Apply(img image.Image) *image.NRGBA {
+	out := imaging.Clone(img)
+	if o.Flip {
+		out = imaging.FlipH(out)
+	}
+	switch o.Rotate {
+	case 90:
+		out = imaging.Rotate90(out)
+	case 180:
+		out = imaging.Rotate180(out)
+	case 270:
+		out = imaging.Rotate270(out)
+	}
+	return out
+}
+
+func (o Orientation) String() string {
+	if o.Flip {
+		return fmt.Sprintf("flipped, rotated %d", o.Rotate)
+	}
+	return fmt.Sprintf("rotated %d", o.Rotate)
+}
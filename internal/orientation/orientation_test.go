@@ -0,0 +1,89 @@
+package orientation
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// every orientation: each rotation, mirrored or not
+func all() []Orientation {
+	var os []Orientation
+	for _, flip := range []bool{false, true} {
+		for _, r := range []int{0, 90, 180, 270} {
+			os = append(os, Orientation{Rotate: r, Flip: flip})
+		}
+	}
+	return os
+}
+
+// a 3x2 image whose pixels are all distinct
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for y := range 2 {
+		for x := range 3 {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	return img
+}
+
+func equal(a, b *image.NRGBA) bool {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return false
+	}
+	for y := range a.Rect.Dy() {
+		for x := range a.Rect.Dx() {
+			if a.NRGBAAt(a.Rect.Min.X+x, a.Rect.Min.Y+y) != b.NRGBAAt(b.Rect.Min.X+x, b.Rect.Min.Y+y) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestApply(t *testing.T) {
+	img := testImage()
+	// rotating 90 counter-clockwise moves the top right pixel to the top left
+	rotated := Orientation{Rotate: 90}.Apply(img)
+	if rotated.Bounds().Size() != image.Pt(2, 3) || rotated.NRGBAAt(0, 0) != img.NRGBAAt(2, 0) {
+		t.Errorf("rotate 90: got size %v, top left %v", rotated.Bounds().Size(), rotated.NRGBAAt(0, 0))
+	}
+	// mirroring happens before rotating
+	flipped := Orientation{Rotate: 90, Flip: true}.Apply(img)
+	if flipped.NRGBAAt(0, 0) != img.NRGBAAt(0, 0) {
+		t.Errorf("flip then rotate 90: got top left %v", flipped.NRGBAAt(0, 0))
+	}
+
+	for _, o := range all() {
+		stored := o.Apply(img)
+		if stored.Bounds().Size() != o.StoredSize(img.Bounds().Size()) {
+			t.Errorf("%v: stored size %v, StoredSize says %v", o, stored.Bounds().Size(), o.StoredSize(img.Bounds().Size()))
+		}
+		if !equal(o.Inverse().Apply(stored), img) {
+			t.Errorf("%v: the inverse doesn't restore the image", o)
+		}
+	}
+}
+
+func TestThen(t *testing.T) {
+	img := testImage()
+	for _, o := range all() {
+		for _, p := range all() {
+			if !equal(o.Then(p).Apply(img), p.Apply(o.Apply(img))) {
+				t.Errorf("%v then %v: got %v, which differs from applying each", o, p, o.Then(p))
+			}
+		}
+	}
+}
+
+func TestRotated(t *testing.T) {
+	for degrees, want := range map[int]int{0: 0, 90: 90, 180: 180, 270: 270, 360: 0, -90: 270, 450: 90} {
+		if o, err := Rotated(degrees); err != nil || o != (Orientation{Rotate: want}) {
+			t.Errorf("Rotated(%d): got %v, %v, want rotated %d", degrees, o, err, want)
+		}
+	}
+	if _, err := Rotated(45); err == nil {
+		t.Error("expected an error for 45 degrees")
+	}
+}
@@ -1,26 +1,112 @@
 package main
 
 import (
+	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
 
 	"github.com/crimro-se/atlas-repacker/internal/boxpack"
 	"github.com/crimro-se/atlas-repacker/internal/findislands"
+	"github.com/crimro-se/atlas-repacker/internal/orientation"
 )
 
-func detectIslands(img image.Image, imgRef int, diagonalDetection bool) ([]boxpack.BoxTranslation, error) {
-	rects := findislands.ImageToIslands(img, diagonalDetection)
-	boxes := make([]boxpack.BoxTranslation, 0, len(rects))
-	for _, rect := range rects {
-		boxes = append(boxes, boxpack.BoxFromRect(imgRef, rect, false))
+// builds the island detector from the detection flags
+func islandDetector(flags myFlags) findislands.Detector {
+	return findislands.Detector{
+		Diagonal:        flags.checkDiagonals,
+		AlphaThreshold:  uint8(flags.alphaThreshold),
+		LumaThreshold:   uint8(flags.lumaThreshold),
+		Merge:           flags.mergeDistance,
+		Contain:         must1(findislands.ContainmentByName(flags.containment)),
+		Workers:         flags.threads,
+		MinPixels:       flags.minPixels,
+		MinWidth:        flags.minWidth,
+		MinHeight:       flags.minHeight,
+		MaxAreaFraction: flags.maxArea,
 	}
-	return boxes, nil
+}
+
+// converts islands detected in input image imgRef to boxes named with prefix.
+// if masks is set, boxes copy only their island's own pixels.
+func islandsToBoxes(islands []findislands.Island, imgRef int, prefix string, masks bool) []NamedBox {
+	boxes := make([]boxpack.BoxTranslation, 0, len(islands))
+	for _, island := range islands {
+		if masks {
+			boxes = append(boxes, boxpack.BoxFromMask(imgRef, island.Rectangle, island.Mask))
+		} else {
+			boxes = append(boxes, boxpack.BoxFromRect(imgRef, island.Rectangle, orientation.Upright))
+		}
+	}
+	named := NamedBoxFromBoxpackSlice(boxes, islandNames(prefix, len(boxes)))
+	for i := range named {
+		named[i].Nested = islands[i].Nested
+	}
+	return named
+}
+
+// under -contained warn, logs how many islands are nested within another's bounding box, and with -debug, each of them
+func reportNested(filename string, boxes []NamedBox, masks bool) {
+	count := 0
+	for _, b := range boxes {
+		for _, nested := range b.Nested {
+			debugMsg(fmt.Sprintf("Island %v in %s lies within %s's bounding box %v", nested, filename, b.Name, b.SourceRect()))
+			count++
+		}
+	}
+	if count == 0 {
+		return
+	}
+	consequence := "their pixels are copied twice. Use -mask or -contained absorb to avoid this, or -debug to list them"
+	if masks {
+		consequence = "they're masked, so this is harmless"
+	}
+	msg(fmt.Sprintf("%d islands in %s lie within another island's bounding box, %s", count, filename, consequence))
+}
+
+// copies a debug image, drawing the islands nested within imgSrc's boxes in red
+func highlightNested(debug image.Image, boxes []NamedBox, imgSrc int) image.Image {
+	out := image.NewRGBA(debug.Bounds())
+	draw.Draw(out, out.Rect, debug, debug.Bounds().Min, draw.Src)
+	red := image.NewUniform(color.RGBA{255, 0, 0, 255})
+	for _, b := range boxes {
+		if b.ImgSrc() != imgSrc {
+			continue
+		}
+		for _, nested := range b.Nested {
+			draw.Draw(out, nested, red, image.Point{}, draw.Src)
+		}
+	}
+	return out
+}
+
+// logs each rejected island, and if sidecar isn't empty, saves an image of the rejected pixels for review.
+func reportRejected(filename string, img image.Image, rejected []findislands.Island, detector findislands.Detector, sidecar string) error {
+	for _, island := range rejected {
+		msg(fmt.Sprintf("Rejected island %v in %s: %s", island.Rectangle, filename, detector.RejectReason(island, img.Bounds())))
+	}
+	if sidecar == "" {
+		return nil
+	}
+	out := image.NewNRGBA(img.Bounds())
+	for _, island := range rejected {
+		if island.Mask != nil {
+			draw.DrawMask(out, island.Rectangle, img, island.Min, island.Mask, island.Min, draw.Src)
+		} else {
+			draw.Draw(out, island.Rectangle, img, island.Min, draw.Src)
+		}
+	}
+	if err := saveImage(sidecar, out); err != nil {
+		return fmt.Errorf("error whilst trying to save (%s): %w", sidecar, err)
+	}
+	return nil
 }
 
 func rectsToBoxTranslation(rr [][]image.Rectangle) []boxpack.BoxTranslation {
 	boxes := make([]boxpack.BoxTranslation, 0, len(rr[0]))
 	for i, rects := range rr {
 		for _, rect := range rects {
-			boxes = append(boxes, boxpack.BoxFromRect(i, rect, false))
+			boxes = append(boxes, boxpack.BoxFromRect(i, rect, orientation.Upright))
 		}
 	}
 	return boxes
@@ -9,6 +9,12 @@ import (
 
 func initLogging() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+}
+
+// shows debugMsg output, for -debug
+func enableDebugLogging() {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
 }
 
 // If any errors exist, logs the (optional) msg and all errors.
@@ -52,3 +58,8 @@ func must2[T1, T2 any](x T1, y T2, err error, msg ...string) (T1, T2) {
 func msg(message string) {
 	log.Info().Msg(message)
 }
+
+// like msg, but only shown with -debug
+func debugMsg(message string) {
+	log.Debug().Msg(message)
+}
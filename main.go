@@ -8,10 +8,18 @@ import (
 	_ "image/gif"
 	_ "image/jpeg"
 	"image/png"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/crimro-se/atlas-repacker/internal/atlas"
 	"github.com/crimro-se/atlas-repacker/internal/boxpack"
+	"github.com/crimro-se/atlas-repacker/internal/chroma"
+	"github.com/crimro-se/atlas-repacker/internal/findislands"
+	"github.com/crimro-se/atlas-repacker/internal/gifframes"
 	_ "golang.org/x/image/webp"
 )
 
@@ -22,11 +30,16 @@ func init() {
 
 type NamedBox struct {
 	boxpack.BoxTranslation
-	Name string
+	Name   string
+	Nested []image.Rectangle // islands detected within this box's source rect, highlighted by -debug
+	Region *atlas.Region     // the atlas region this box was loaded from, nil if detected
 }
 
 func main() {
-	errored := 0
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+
 	//
 	// 1. Flag parsing
 	//
@@ -37,115 +50,208 @@ func main() {
 		flag.Usage()
 		os.Exit(1)
 	}
+	flags.packing = must1(buildPackConfig(flags))
+	if flags.debug {
+		enableDebugLogging()
+	}
+
+	if flags.batchDir != "" {
+		os.Exit(runBatch(flags, inputFiles))
+	}
+
+	unpacked, err := repack(flags, inputFiles)
+	errHandler(err)
+
+	// exit status
+	if unpacked > 0 {
+		os.Exit(1)
+	}
+}
 
+// repacks the islands of inputFiles into the output described by flags.
+// returns the number of boxes which couldn't be packed.
+func repack(flags myFlags, inputFiles []string) (int, error) {
 	//
 	// 2. Box Packing
 	//
-	images, err := loadAllImages(inputFiles)
-	errHandler(err, "an error occured whilst loading images")
+	images, sources, err := loadAllImages(inputFiles, flags.gifFrames, flags.loadAtlas)
+	if err != nil {
+		return 0, fmt.Errorf("an error occured whilst loading images: %w", err)
+	}
+	if flags.chromaKey != "" {
+		key, err := chroma.ParseKey(flags.chromaKey)
+		if err != nil {
+			return 0, err
+		}
+		if images, err = applyChromaKey(images, sources, key); err != nil {
+			return 0, err
+		}
+	}
+	if flags.untrim {
+		images, sources = untrimRegions(images, sources)
+	}
 
 	// find pixel islands via atlas file or look at the pixels.
 	var namedBoxes []NamedBox
-	namedBoxes, err = loadOrDetectBoxes(images, inputFiles, flags)
-	errHandler(err)
+	namedBoxes, err = loadOrDetectBoxes(images, sources, flags)
+	if err != nil {
+		return 0, err
+	}
 	if len(namedBoxes) < 1 {
-		errHandler(errors.New("no pixel islands detected in the input image(s)"))
+		return 0, errors.New("no pixel islands detected in the input image(s)")
 	}
 
 	if flags.debug {
 		boxes := BoxpackSliceFromNamedBoxes(namedBoxes)
-		img := boxpack.DebugViewRects(boxes, images[0].Bounds().Dx(), images[0].Bounds().Dy(), true, 0)
-		errHandler(saveImage("debug.png", img))
-		msg("debug.png has been written")
-		if len(inputFiles) > 1 {
+		img := boxpack.DebugViewRects(boxes, images[0].Bounds(), true, 0)
+		img = highlightNested(img, namedBoxes, 0)
+		if err := saveImage(flags.debugFileName, img); err != nil {
+			return 0, err
+		}
+		msg(flags.debugFileName + " has been written")
+		if len(images) > 1 {
 			msg("NOTE: only the first image you loaded has been debugged.")
 		}
 	}
 
 	var unpacked int
-	unpacked = PackNamedBoxes(namedBoxes, flags.width, flags.height, flags.margin, getOffset(flags))
+	var strategy boxpack.Strategy
+	unpacked, strategy = PackNamedBoxes(namedBoxes, flags.packing, flags.width, flags.height, flags.margin, getOffset(flags))
 	//
 	// 2.1 bruteforce w,h if requested
 	//
 	if flags.minimumSquareMode > 0 {
 		wh := (EstimateOutputWH(namedBoxes, flags.margin) / flags.minimumSquareMode) * flags.minimumSquareMode
-		unpacked = PackNamedBoxes(namedBoxes, wh, wh, flags.margin, getOffset(flags))
+		unpacked, strategy = PackNamedBoxes(namedBoxes, flags.packing, wh, wh, flags.margin, getOffset(flags))
 		for unpacked > 0 {
 			wh += flags.minimumSquareMode
-			unpacked = PackNamedBoxes(namedBoxes, wh, wh, flags.margin, getOffset(flags))
+			unpacked, strategy = PackNamedBoxes(namedBoxes, flags.packing, wh, wh, flags.margin, getOffset(flags))
 		}
 		flags.width = wh
 		flags.height = wh
 		msg(fmt.Sprintf("Calculated output size (W&H): %d", wh))
 	}
 
+	if flags.minimumSizeMode {
+		constraints := boxpack.SizeConstraints{
+			PowerOfTwo: flags.powerOfTwo,
+			Multiple:   flags.sizeMultiple,
+			MaxAspect:  flags.maxAspect,
+		}
+		if flags.fixedWidth {
+			constraints.FixedWidth = flags.width
+		}
+		w, h, attempts := FindMinSize(namedBoxes, flags.packing, flags.margin, constraints)
+		if w == 0 {
+			return 0, errors.New("no output size satisfies the constraints given")
+		}
+		flags.width = w
+		flags.height = h
+		unpacked, strategy = PackNamedBoxes(namedBoxes, flags.packing, flags.width, flags.height, flags.margin, getOffset(flags))
+		msg(fmt.Sprintf("Calculated output size: %dx%d (%d pack attempts)", w, h, attempts+1))
+	}
+
 	//
 	// 2.2 maximum margin finder
-	//     todo: double margin then backoff in a binary-search fashion
+	//
 	if flags.maximumMarginMode && unpacked == 0 {
-		// we need a clone of the boxes for our tests
-		boxes2 := make([]NamedBox, len(namedBoxes))
-		copy(boxes2, namedBoxes)
-		for unpacked == 0 {
-			flags.margin++
-			unpacked = PackNamedBoxes(boxes2, flags.width, flags.height, flags.margin, getOffset(flags))
-			if unpacked == 0 {
-				namedBoxes = boxes2
-			}
-		}
-		unpacked = 0
-		flags.margin--
-		msg(fmt.Sprintf("Margin chosen: %d", flags.margin))
+		margin, attempts := FindMaxMargin(namedBoxes, flags.packing, flags.width, flags.height, flags.margin)
+		flags.margin = margin
+		unpacked, strategy = PackNamedBoxes(namedBoxes, flags.packing, flags.width, flags.height, flags.margin, getOffset(flags))
+		msg(fmt.Sprintf("Margin chosen: %d (%d pack attempts)", flags.margin, attempts+1))
 	}
 
 	if flags.maximumMarginMode && unpacked > 0 {
 		msg("Note: margin detection skipped as we already can't pack everything")
 	}
 
+	//
+	// 2.3 spread across multiple pages if requested
+	//
+	pages := [][]NamedBox{namedBoxes}
+	if flags.pages {
+		pages, unpacked = PackAllNamedBoxes(namedBoxes, flags.packing, flags.width, flags.height, flags.margin, getOffset(flags), flags.maxPages)
+		msg(fmt.Sprintf("Packed onto %d page(s)", len(pages)))
+	}
+
+	if !flags.pages && len(flags.packing.Strategies()) > 1 {
+		msg("Packing strategy chosen: " + strategy.String())
+	}
+
 	if unpacked > 0 {
 		msg(fmt.Sprintf("Note: %d boxes couldn't be packed", unpacked))
-		errored = 1
 	}
 
 	//
-	// 2.3 save output
+	// 2.4 save output
 	//
-	outImg := image.NewNRGBA(image.Rect(0, 0, flags.width, flags.height))
-	boxesTR := BoxpackSliceFromNamedBoxes(namedBoxes)
-	boxpack.RenderAll(images, boxesTR, outImg)
-	errHandler(saveImage(flags.outputFileName, outImg))
+	pageFiles := []string{flags.outputFileName}
+	if flags.pages {
+		pageFiles = pageFilenames(flags.outputFileName, len(pages))
+	}
+	for i, page := range pages {
+		outImg := image.NewNRGBA(image.Rect(0, 0, flags.width, flags.height))
+		boxesTR := BoxpackSliceFromNamedBoxes(page)
+		boxpack.RenderAll(images, boxesTR, outImg)
+		if err := saveImage(pageFiles[i], outImg); err != nil {
+			return unpacked, err
+		}
+	}
 
-	// exit status
-	os.Exit(errored)
+	if flags.atlasOut {
+		atlasFilename := atlas.FilepathsToDotAtlas([]string{flags.outputFileName})[0]
+		if err := writeAtlasFile(atlasFilename, pageFiles, flags.width, flags.height, pages, premultiplied(sources)); err != nil {
+			return unpacked, err
+		}
+		msg(atlasFilename + " has been written")
+	}
+
+	return unpacked, nil
 }
 
 // either detects pixel islands in images or loads the bounds from .atlas files, depending on
 // the specified flags
-func loadOrDetectBoxes(images []image.Image, filenames []string, cfg myFlags) ([]NamedBox, error) {
+func loadOrDetectBoxes(images []image.Image, sources []imageSource, cfg myFlags) ([]NamedBox, error) {
 	boxes := make([]NamedBox, 0, 8)
-	atlasFiles := atlas.FilepathsToDotAtlas(filenames)
-	for i, img := range images {
-		detectRequired := true // disabled if we successfully load from atlas.
-		if cfg.loadAtlas {
-			b, e := parseAtlasFile(atlasFiles[i], i)
-			if e == nil {
-				// filter if required
-				if len(cfg.atlasFilter) > 0 {
-					b = namedBoxFilter(b, cfg.atlasFilter)
-				}
-				boxes = append(boxes, b...)
-				detectRequired = false
+	sidecars := make([]string, len(images))
+	if cfg.rejectedOut != "" {
+		sidecars = rejectedFilenames(cfg.rejectedOut, len(images))
+	}
+	loaded := make([][]NamedBox, len(images))
+	toDetect := make([]int, 0, len(images)) // those without an atlas page
+	for i := range images {
+		if page := sources[i].page; page != nil {
+			b := atlasToBoxes(i, page.Regions)
+			// filter if required
+			if len(cfg.atlasFilter) > 0 {
+				b = namedBoxFilter(b, cfg.atlasFilter)
 			}
+			loaded[i] = b
+			continue
 		}
-		if detectRequired {
-			b, e := detectIslands(img, i, cfg.checkDiagonals)
-			if e != nil {
-				return boxes, e
-			}
-			nb := NamedBoxFromBoxpackSlice(b, nil)
-			boxes = append(boxes, nb...)
+		toDetect = append(toDetect, i)
+	}
+
+	detector := islandDetector(cfg)
+	detectImages := make([]image.Image, len(toDetect))
+	for j, i := range toDetect {
+		detectImages[j] = images[i]
+	}
+	kept, rejected := detector.DetectAll(detectImages, cfg.masks || cfg.rejectedOut != "")
+	prefixes := islandPrefixes(sources)
+	for j, i := range toDetect {
+		if e := reportRejected(sources[i].String(), images[i], rejected[j], detector, sidecars[i]); e != nil {
+			return boxes, e
+		}
+		loaded[i] = islandsToBoxes(kept[j], i, prefixes[i], cfg.masks)
+		if detector.Contain == findislands.ContainWarn {
+			reportNested(sources[i].String(), loaded[i], cfg.masks)
 		}
 	}
+
+	for _, b := range loaded {
+		boxes = append(boxes, b...)
+	}
 	return boxes, nil
 }
 
@@ -163,6 +269,40 @@ func getOffset(flags myFlags) int {
 	return offset
 }
 
+// derives a numbered filename for each output page, eg: output.png -> output_0.png, output_1.png ...
+func pageFilenames(filename string, count int) []string {
+	names := make([]string, count)
+	for i := range names {
+		names[i] = pageFilename(filename, i)
+	}
+	return names
+}
+
+// derives the filename of one output page, eg: output.png, 1 -> output_1.png
+func pageFilename(filename string, page int) string {
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%s_%d%s", filename[:len(filename)-len(ext)], page, ext)
+}
+
+// true if name is one of the page filenames derived from filename, for any number of pages
+func isPageFilename(name, filename string) bool {
+	ext := filepath.Ext(filename)
+	number, ok := strings.CutPrefix(name, filename[:len(filename)-len(ext)]+"_")
+	if !ok || !strings.HasSuffix(number, ext) {
+		return false
+	}
+	page, err := strconv.Atoi(strings.TrimSuffix(number, ext))
+	return err == nil && page >= 0 && pageFilename(filename, page) == name
+}
+
+// one sidecar image of rejected islands per input, numbered as pages are if there are several
+func rejectedFilenames(filename string, count int) []string {
+	if count == 1 {
+		return []string{filename}
+	}
+	return pageFilenames(filename, count)
+}
+
 func saveImage(fileName string, img image.Image) error {
 	fp, err := os.Create(fileName)
 	if err != nil {
@@ -173,20 +313,106 @@ func saveImage(fileName string, img image.Image) error {
 	return err
 }
 
-// loads all input files as image.Image types.
-func loadAllImages(files []string) ([]image.Image, error) {
+// where a loaded image came from: a whole file, or one frame of an animated GIF
+type imageSource struct {
+	filename string
+	frame    int         // index of the frame within its GIF, -1 for a whole file
+	page     *atlas.Page // the atlas page describing this image's regions, nil if they're detected
+}
+
+func (s imageSource) String() string {
+	if s.frame < 0 {
+		return s.filename
+	}
+	return fmt.Sprintf("%s frame %d", s.filename, s.frame)
+}
+
+// loads all input files as image.Image types, along with where each came from.
+// if gifFrames is set, every frame of a GIF is loaded as a separate image, otherwise only the first.
+// .atlas inputs load each of their pages. if loadAtlas is set, so do .atlas files named after an input image,
+// and if one of those can't be loaded, it's logged and the input image's islands are detected instead.
+func loadAllImages(files []string, gifFrames, loadAtlas bool) ([]image.Image, []imageSource, error) {
 	images := make([]image.Image, 0, len(files))
+	sources := make([]imageSource, 0, len(files))
 	for _, inputFile := range files {
-		fp, err := os.Open(inputFile)
+		var imgs []image.Image
+		var srcs []imageSource
+		var err error
+		if strings.EqualFold(filepath.Ext(inputFile), ".atlas") {
+			imgs, srcs, err = loadAtlasPages(inputFile, nil, imageSource{})
+		} else {
+			imgs, srcs, err = loadImage(inputFile, gifFrames)
+			// an atlas describes a whole file, so animation frames are always detected
+			atlasFile := atlas.FilepathsToDotAtlas([]string{inputFile})[0]
+			if err == nil && loadAtlas && srcs[0].frame < 0 && fileExists(atlasFile) {
+				pageImgs, pageSrcs, atlasErr := loadAtlasPages(atlasFile, imgs[0], srcs[0])
+				if atlasErr == nil {
+					imgs, srcs = pageImgs, pageSrcs
+				} else {
+					logErrors([]error{atlasErr}, "Detecting islands in "+inputFile+" instead of loading "+atlasFile)
+				}
+			}
+		}
 		if err != nil {
-			return images, err
+			return images, sources, err
 		}
-		defer fp.Close()
-		img, _, err := image.Decode(fp)
+		images = append(images, imgs...)
+		sources = append(sources, srcs...)
+	}
+	return images, sources, nil
+}
+
+// loads an image file. if gifFrames is set and it's a GIF, each frame is loaded separately.
+func loadImage(filename string, gifFrames bool) ([]image.Image, []imageSource, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fp.Close()
+	img, format, err := image.Decode(fp)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !gifFrames || format != "gif" {
+		return []image.Image{img}, []imageSource{{filename: filename, frame: -1}}, nil
+	}
+
+	// decode again, this time keeping every frame
+	if _, err = fp.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	frames, err := gifframes.Decode(fp)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error whilst loading frames (%s): %w", filename, err)
+	}
+	images := make([]image.Image, len(frames))
+	sources := make([]imageSource, len(frames))
+	for i, frame := range frames {
+		images[i] = frame
+		sources[i] = imageSource{filename: filename, frame: i}
+	}
+	return images, sources, nil
+}
+
+// true if filename exists, whether or not it can be read
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// replaces each image with a copy in which pixels matching key are transparent,
+// so they're neither detected as islands nor rendered.
+func applyChromaKey(images []image.Image, sources []imageSource, key chroma.Key) ([]image.Image, error) {
+	keyed := make([]image.Image, 0, len(images))
+	for i, img := range images {
+		col, err := key.ColorFor(img)
 		if err != nil {
-			return images, err
+			return images, fmt.Errorf("error whilst detecting chroma key (%s): %w", sources[i], err)
+		}
+		if key.Auto {
+			msg(fmt.Sprintf("Chroma key for %s: #%02x%02x%02x", sources[i], col.R, col.G, col.B))
 		}
-		images = append(images, img)
+		keyed = append(keyed, chroma.Apply(img, col, key.Tolerance))
 	}
-	return images, nil
+	return keyed, nil
 }
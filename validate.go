@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/crimro-se/atlas-repacker/internal/atlas"
+)
+
+// runs the validate subcommand, printing file:line: message for every problem found in the atlas files of args.
+// returns the exit status, 1 if any problems were found.
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	noImages := flags.Bool("noimages", false, "only check the atlas files, not their regions against the page images")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage of %s validate:\n\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), os.Args[0], "validate", "[flags]", "input.atlas [input2.atlas ...]")
+		fmt.Fprintln(flags.Output(), "Flags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		logErrors([]error{errors.New("no atlas files specified")})
+		flags.Usage()
+		return 1
+	}
+
+	var problems int
+	for _, filename := range flags.Args() {
+		diagnostics, err := validateAtlasFile(filename, !*noImages)
+		if err != nil {
+			logErrors([]error{err})
+			problems++
+			continue
+		}
+		for _, d := range diagnostics {
+			fmt.Printf("%s:%d: %s\n", filename, d.Line, d.Message)
+		}
+		problems += len(diagnostics)
+	}
+	if problems > 0 {
+		msg(fmt.Sprintf("%d problems found", problems))
+		return 1
+	}
+	return 0
+}
+
+// reports every problem in an atlas file, in line order. if checkImages is set,
+// regions are checked against the page images, found relative to the atlas file.
+func validateAtlasFile(filename string, checkImages bool) ([]atlas.Diagnostic, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error whilst trying to open (%s): %w", filename, err)
+	}
+	defer fp.Close()
+	a, diagnostics := atlas.Validate(fp)
+	if len(a.Pages) == 0 {
+		diagnostics = append(diagnostics, atlas.Diagnostic{Line: 1, Message: "no pages found"})
+	}
+
+	if checkImages {
+		for _, page := range a.Pages {
+			size, err := imageSize(filepath.Join(filepath.Dir(filename), filepath.FromSlash(page.Name)))
+			if err != nil {
+				diagnostics = append(diagnostics, atlas.Diagnostic{Line: page.Line, Message: err.Error()})
+				continue
+			}
+			// regions were checked against the declared size whilst parsing
+			if size != page.Size {
+				diagnostics = append(diagnostics, page.CheckBounds(size)...)
+			}
+		}
+	}
+	sort.SliceStable(diagnostics, func(i, j int) bool { return diagnostics[i].Line < diagnostics[j].Line })
+	return diagnostics, nil
+}
+
+// the size of an image file, read from its header alone
+func imageSize(filename string) (image.Point, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return image.Point{}, fmt.Errorf("error whilst trying to open page image (%s): %w", filename, err)
+	}
+	defer fp.Close()
+	cfg, _, err := image.DecodeConfig(fp)
+	if err != nil {
+		return image.Point{}, fmt.Errorf("error whilst trying to read page image (%s): %w", filename, err)
+	}
+	return image.Pt(cfg.Width, cfg.Height), nil
+}
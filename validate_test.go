@@ -0,0 +1,57 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValidateAtlasFile(t *testing.T) {
+	dir := t.TempDir()
+	fp, err := os.Create(filepath.Join(dir, "a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(fp, image.NewNRGBA(image.Rect(0, 0, 16, 16))); err != nil {
+		t.Fatal(err)
+	}
+	fp.Close()
+	// turned only fits the image because it is stored rotated, sideways only fits unrotated
+	data := `a.png
+inside
+  bounds: 0,0,16,16
+outside
+  bounds: 8,8,10,4
+turned
+  bounds: 12,0,16,4
+  rotate: 90
+sideways
+  bounds: 0,12,16,4
+  rotate: 270
+
+missing.png
+x
+  bounds: 0,0,1,1
+`
+	filename := filepath.Join(dir, "a.atlas")
+	if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for checkImages, want := range map[bool][]int{false: nil, true: {4, 9, 13}} {
+		diagnostics, err := validateAtlasFile(filename, checkImages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []int
+		for _, d := range diagnostics {
+			lines = append(lines, d.Line)
+		}
+		if !reflect.DeepEqual(lines, want) {
+			t.Errorf("checkImages %v: problems on lines %v, want %v: %v", checkImages, lines, want, diagnostics)
+		}
+	}
+}